@@ -0,0 +1,96 @@
+package mango
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+)
+
+type envContextKey struct{}
+
+// Find the Env of the mango stack a request is being served by, if any.
+// It's set on requests passed to handlers used with FromHandler and
+// FromHTTPMiddleware.
+func EnvFromContext(ctx context.Context) (Env, bool) {
+	env, ok := ctx.Value(envContextKey{}).(Env)
+	return env, ok
+}
+
+// Use a net/http Handler as a mango App. The handler's response is
+// buffered and returned as the Status, Headers and Body.
+func FromHandler(handler http.Handler) App {
+	return func(env Env) (Status, Headers, Body) {
+		ctx := context.WithValue(env.Context(), envContextKey{}, env)
+		recorder := newResponseRecorder()
+		handler.ServeHTTP(recorder, env.Request().WithContext(ctx))
+		return recorder.result()
+	}
+}
+
+// Use net/http middleware, of the form func(http.Handler) http.Handler,
+// as mango Middleware. Any changes it makes to the request are seen by
+// the apps it wraps, and the Env is carried through to them.
+func FromHTTPMiddleware(middleware func(http.Handler) http.Handler) Middleware {
+	return func(env Env, app App) (Status, Headers, Body) {
+		return FromHandler(middleware(ToHandler(app)))(env)
+	}
+}
+
+// Use a mango App as a net/http Handler. When the handler is called from
+// inside a mango stack (via FromHandler or FromHTTPMiddleware), the app
+// is given that stack's Env, with the request swapped for the one the
+// handler was given.
+func ToHandler(app App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env, ok := EnvFromContext(r.Context())
+		if !ok {
+			serve(app, w, r)
+			return
+		}
+
+		// Restore the outer request once we're done, so changes made by
+		// net/http middleware only apply to the apps it wraps.
+		request, ctx := env["mango.request"], env["mango.context"]
+		defer func() {
+			env["mango.request"], env["mango.context"] = request, ctx
+		}()
+
+		env["mango.request"] = &Request{r}
+		env["mango.context"] = r.Context()
+		respond(env, app, w)
+	})
+}
+
+// responseRecorder captures the response written by a net/http Handler
+type responseRecorder struct {
+	status  int
+	headers http.Header
+	body    bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{headers: make(http.Header)}
+}
+
+func (this *responseRecorder) Header() http.Header {
+	return this.headers
+}
+
+func (this *responseRecorder) WriteHeader(status int) {
+	if this.status == 0 {
+		this.status = status
+	}
+}
+
+func (this *responseRecorder) Write(p []byte) (int, error) {
+	this.WriteHeader(http.StatusOK)
+	return this.body.Write(p)
+}
+
+// The whole response is buffered, so there's nothing to flush.
+func (this *responseRecorder) Flush() {}
+
+func (this *responseRecorder) result() (Status, Headers, Body) {
+	this.WriteHeader(http.StatusOK)
+	return Status(this.status), Headers(this.headers), Body(this.body.String())
+}
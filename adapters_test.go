@@ -0,0 +1,143 @@
+package mango
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFromHandler(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env, ok := EnvFromContext(r.Context())
+		if !ok {
+			t.Error("Expected the Env to be carried in the request context")
+		} else if env["test.value"] != "foo" {
+			t.Error("Expected env[\"test.value\"] to equal: \"foo\", got:", env["test.value"])
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(201)
+		fmt.Fprint(w, "Created ", r.URL.Path)
+	})
+
+	stack := new(Stack)
+	handlerApp := stack.Compile(FromHandler(handler))
+
+	request, err := http.NewRequest("POST", "http://localhost:3000/things", nil)
+	status, headers, body := handlerApp(Env{"mango.request": &Request{request}, "test.value": "foo"})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if status != 201 {
+		t.Error("Expected status to equal 201, got:", status)
+	}
+
+	if headers.Get("Content-Type") != "text/plain" {
+		t.Error("Expected Content-Type to equal \"text/plain\", got:", headers.Get("Content-Type"))
+	}
+
+	expected := "Created /things"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestFromHandlerDefaultStatus(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	status, _, _ := FromHandler(handler)(Env{"mango.request": &Request{request}})
+
+	if status != 200 {
+		t.Error("Expected status to equal 200, got:", status)
+	}
+}
+
+func TestFromHTTPMiddleware(t *testing.T) {
+	adaptersTestServer := func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body(env.Request().URL.Path + " " + env["test.value"].(string))
+	}
+
+	// Check the outer request is back in place once the middleware returns
+	outerPath := func(env Env, app App) (Status, Headers, Body) {
+		status, headers, body := app(env)
+		headers.Set("X-Outer-Path", env.Request().URL.Path)
+		return status, headers, body
+	}
+
+	stack := new(Stack)
+	stack.Use(outerPath)
+	stack.Use(FromHTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.StripPrefix("/api", next)
+	}))
+	stack.Use(FromHTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Wrapped", "true")
+			next.ServeHTTP(w, r)
+		})
+	}))
+	adaptersApp := stack.Compile(adaptersTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/api/users", nil)
+	status, headers, body := adaptersApp(Env{"mango.request": &Request{request}, "test.value": "foo"})
+
+	if status != 200 {
+		t.Error("Expected status to equal 200, got:", status)
+	}
+
+	if headers.Get("X-Wrapped") != "true" {
+		t.Error("Expected X-Wrapped to equal \"true\", got:", headers.Get("X-Wrapped"))
+	}
+
+	if headers.Get("X-Outer-Path") != "/api/users" {
+		t.Error("Expected X-Outer-Path to equal \"/api/users\", got:", headers.Get("X-Outer-Path"))
+	}
+
+	expected := "/users foo"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestFromHTTPMiddlewareStreaming(t *testing.T) {
+	stack := new(Stack)
+	stack.Use(FromHTTPMiddleware(func(next http.Handler) http.Handler {
+		return next
+	}))
+	testServer := httptest.NewServer(stack.HandlerFunc(streamingTestServer))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	// The stream should be sent once, from inside the net/http middleware
+	body, _ := ioutil.ReadAll(response.Body)
+	expected := "chunk chunk chunk "
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestToHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/hello", ToHandler(helloWorld))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://localhost:3000/hello", nil)
+	mux.ServeHTTP(recorder, request)
+
+	if recorder.Code != 200 {
+		t.Error("Expected status to equal 200, got:", recorder.Code)
+	}
+
+	if recorder.Body.String() != "Hello World!" {
+		t.Error("Expected body:", recorder.Body.String(), "to equal: \"Hello World!\"")
+	}
+}
@@ -4,49 +4,117 @@ import (
 	"encoding/base64"
 	"errors"
 	"strings"
+	"unicode/utf8"
 )
 
-func defaultFailure() (Status, Headers, Body) {
-	return 401, Headers{"WWW-Authenticate": []string{"Basic realm=\"Basic\""}, "Content-Type": []string{"text/html"}}, Body("Access Denied.") // default failure page
+func basicAuthFailure(options *BasicAuthOptions) (Status, Headers, Body) {
+	realm := options.Realm
+	if realm == "" {
+		realm = "Basic"
+	}
+	challenge := "Basic realm=" + quoteAuthParam(realm)
+	if options.UTF8 {
+		challenge += ", charset=\"UTF-8\""
+	}
+	return 401, Headers{"WWW-Authenticate": []string{challenge}, "Content-Type": []string{"text/html"}}, Body("Access Denied.") // default failure page
+}
+
+// Quote a WWW-Authenticate parameter value
+func quoteAuthParam(value string) string {
+	return "\"" + authParamEscaper.Replace(value) + "\""
+}
+
+var authParamEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+func forbidden() (Status, Headers, Body) {
+	return 403, Headers{"Content-Type": []string{"text/html"}}, Body("Forbidden.")
+}
+
+type BasicAuthOptions struct {
+	// The realm sent to clients. Defaults to "Basic".
+	Realm string
+	// Ask clients to send usernames and passwords as UTF-8 (RFC 7617).
+	// Credentials which aren't valid UTF-8 are passed to Auth with an error.
+	UTF8 bool
+	// Returns the accepted user, with any roles or claims, or nil to reject
+	// them. If nil, every request is rejected.
+	Auth func(string, string, Request, error) *User
+	// If nil, a default failure page will be used
+	Failure func(Env) (Status, Headers, Body)
 }
 
 func BasicAuth(auth func(string, string, Request, error) bool, failure func(Env) (Status, Headers, Body)) Middleware {
+	if auth == nil {
+		return BasicAuthUser(nil, failure)
+	}
+	return BasicAuthUser(func(username string, password string, req Request, err error) *User {
+		if auth(username, password, req, err) { // check users auth function
+			return &User{Name: username}
+		}
+		return nil
+	}, failure)
+}
+
+// Like BasicAuth, but the auth function returns the accepted user, with any
+// roles or claims, or nil to reject them. The user is available from
+// env.User().
+func BasicAuthUser(auth func(string, string, Request, error) *User, failure func(Env) (Status, Headers, Body)) Middleware {
+	return BasicAuthWithOptions(&BasicAuthOptions{Auth: auth, Failure: failure})
+}
+
+func BasicAuthWithOptions(options *BasicAuthOptions) Middleware {
 	return func(env Env, app App) (Status, Headers, Body) {
+		delete(env, "mango.user")
 
-		if auth == nil { // fail auth by default if you use this middleware
-			return defaultFailure()
+		if options.Auth == nil { // fail auth by default if you use this middleware
+			return basicAuthFailure(options)
 		}
 
 		username, password, err := getAuth(env.Request())
+		if err == nil && options.UTF8 && !(utf8.ValidString(username) && utf8.ValidString(password)) {
+			err = errors.New("Credentials are not UTF-8")
+		}
 
-		if auth(username, password, *env.Request(), err) { // check users auth function 
+		if user := options.Auth(username, password, *env.Request(), err); user != nil { // check users auth function
+			env["mango.user"] = user
 			return app(env)
 		}
 
-		if failure == nil { // if no special failure function
-			return defaultFailure()
+		if options.Failure == nil { // if no special failure function
+			return basicAuthFailure(options)
 		}
 
-		return failure(env)
+		return options.Failure(env)
 	}
 }
 
 // get username and password from header
 func getAuth(req *Request) (string, string, error) {
 
-	auth64 := req.Header.Get("Authorization")
+	header := req.Header.Get("Authorization")
 
-	if auth64 == "" {
+	if header == "" {
 		return "", "", errors.New("No Authorization Header")
 	}
 
-	auth, err := base64.StdEncoding.DecodeString(strings.Replace(auth64, "Basic ", "", 1))
+	// The scheme is case-insensitive, and may be followed by several spaces
+	split := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(split) != 2 || !strings.EqualFold(split[0], "Basic") {
+		return "", "", errors.New("Not Basic Authorization")
+	}
+
+	auth, err := base64.StdEncoding.DecodeString(strings.TrimSpace(split[1]))
 
 	if err != nil {
 		return "", "", err
 	}
 
-	result := strings.Split(string(auth), ":")
+	// Passwords may contain colons, but usernames can't
+	result := strings.SplitN(string(auth), ":", 2)
+
+	if len(result) != 2 {
+		return "", "", errors.New("Malformed Basic Authorization")
+	}
 
 	return result[0], result[1], nil
 }
@@ -1,6 +1,7 @@
 package mango
 
 import (
+	"encoding/base64"
 	"net/http"
 	"testing"
 )
@@ -85,3 +86,98 @@ func TestFailByDefault(t *testing.T) {
 		t.Error("Request did not succeed, expected status 403, got:", status)
 	}
 }
+
+func TestBasicAuthUser(t *testing.T) {
+	userPage := func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body(env.User().Name)
+	}
+
+	basicAuthStack := new(Stack)
+	basicAuthStack.Middleware(BasicAuth(auth, failurePage))
+	basicAuthApp := basicAuthStack.Compile(userPage)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.SetBasicAuth("foo", "foo")
+	status, _, body := basicAuthApp(Env{"mango.request": &Request{request}})
+
+	if status != 200 || string(body) != "foo" {
+		t.Error("Expected the user to be foo, got:", status, string(body))
+	}
+}
+
+func TestGetAuth(t *testing.T) {
+	encode := func(credentials string) string {
+		return base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	valid := map[string][2]string{
+		"Basic " + encode("foo:bar"):     {"foo", "bar"},
+		"basic " + encode("foo:bar"):     {"foo", "bar"},
+		"BASIC   " + encode("foo:bar"):   {"foo", "bar"},
+		"Basic " + encode("foo:b:a:r"):   {"foo", "b:a:r"},
+		"Basic " + encode("foo:"):        {"foo", ""},
+		"Basic " + encode("fö:pässwörd"): {"fö", "pässwörd"},
+	}
+	for header, expected := range valid {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		request.Header.Set("Authorization", header)
+		username, password, err := getAuth(&Request{request})
+
+		if err != nil || username != expected[0] || password != expected[1] {
+			t.Error("Expected", header, "to give:", expected, "got:", username, password, err)
+		}
+	}
+
+	invalid := []string{
+		"Basic " + encode("foo"),
+		"Bearer " + encode("foo:bar"),
+		"Basic",
+		"Basic not*base64",
+	}
+	for _, header := range invalid {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		request.Header.Set("Authorization", header)
+
+		if _, _, err := getAuth(&Request{request}); err == nil {
+			t.Error("Expected an error for:", header)
+		}
+	}
+}
+
+func TestBasicAuthRealm(t *testing.T) {
+	basicAuthStack := new(Stack)
+	basicAuthStack.Middleware(BasicAuthWithOptions(&BasicAuthOptions{
+		Realm: `My "App"`,
+		UTF8:  true,
+		Auth: func(username string, password string, req Request, err error) *User {
+			if err != nil {
+				return nil
+			}
+			return &User{Name: username}
+		},
+	}))
+	basicAuthApp := basicAuthStack.Compile(successPage)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	status, headers, _ := basicAuthApp(Env{"mango.request": &Request{request}})
+
+	expected := `Basic realm="My \"App\"", charset="UTF-8"`
+	if status != 401 || headers["WWW-Authenticate"][0] != expected {
+		t.Error("Expected WWW-Authenticate:", expected, "got:", status, headers["WWW-Authenticate"])
+	}
+
+	// Credentials which aren't UTF-8 are rejected
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.SetBasicAuth("f\xff", "foo")
+	status, _, _ = basicAuthApp(Env{"mango.request": &Request{request}})
+	if status != 401 {
+		t.Error("Expected status: 401, got:", status)
+	}
+
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.SetBasicAuth("fö", "foo")
+	status, _, _ = basicAuthApp(Env{"mango.request": &Request{request}})
+	if status != 200 {
+		t.Error("Expected status: 200, got:", status)
+	}
+}
@@ -0,0 +1,137 @@
+package mango
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+// Checks bcrypt password hashes ("$2a$", "$2b$" and "$2y$"), as made by
+// `htpasswd -B`. Implemented here so mango needs nothing outside the
+// standard library.
+
+var errBcryptHash = errors.New("mango: malformed bcrypt hash")
+
+// bcrypt's base 64 alphabet, without padding
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+// "OrpheanBeholderScryDoubt", which is encrypted to make the hash
+var bcryptMagic = [6]uint32{0x4f727068, 0x65616e42, 0x65686f6c, 0x64657253, 0x63727944, 0x6f756274}
+
+// Whether the password matches the bcrypt hash
+func bcryptMatches(hash, password string) (bool, error) {
+	// $2y$10$ followed by 22 characters of salt and 31 of hash
+	if len(hash) != 60 || hash[0] != '$' || hash[1] != '2' || hash[3] != '$' || hash[6] != '$' {
+		return false, errBcryptHash
+	}
+	switch hash[2] {
+	case 'a', 'b', 'y':
+	default:
+		return false, errBcryptHash
+	}
+	cost, err := strconv.Atoi(hash[4:6])
+	if err != nil || cost < 4 || cost > 31 {
+		return false, errBcryptHash
+	}
+	salt, err := bcryptEncoding.DecodeString(hash[7:29])
+	if err != nil || len(salt) != 16 {
+		return false, errBcryptHash
+	}
+
+	sum := bcryptEncoding.EncodeToString(bcryptSum(cost, salt, []byte(password)))
+	return subtle.ConstantTimeCompare([]byte(sum), []byte(hash[29:])) == 1, nil
+}
+
+// Encrypt the magic text with the expensive key schedule, giving the 23
+// bytes the hash keeps
+func bcryptSum(cost int, salt, password []byte) []byte {
+	// The key includes the terminating NUL, and is cut to 72 bytes
+	key := append(append([]byte{}, password...), 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+
+	state := newBlowfish()
+	state.expandKey(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		state.expandKey(key, nil)
+		state.expandKey(salt, nil)
+	}
+
+	text := bcryptMagic
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(text); j += 2 {
+			text[j], text[j+1] = state.encrypt(text[j], text[j+1])
+		}
+	}
+
+	sum := make([]byte, 0, 24)
+	for _, word := range text {
+		sum = append(sum, byte(word>>24), byte(word>>16), byte(word>>8), byte(word))
+	}
+	return sum[:23]
+}
+
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+func newBlowfish() *blowfish {
+	return &blowfish{p: blowfishP, s: [4][256]uint32{blowfishS0, blowfishS1, blowfishS2, blowfishS3}}
+}
+
+func (this *blowfish) f(x uint32) uint32 {
+	return ((this.s[0][x>>24] + this.s[1][x>>16&0xff]) ^ this.s[2][x>>8&0xff]) + this.s[3][x&0xff]
+}
+
+func (this *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	for i := 0; i < 16; i += 2 {
+		l ^= this.p[i]
+		r ^= this.f(l)
+		r ^= this.p[i+1]
+		l ^= this.f(r)
+	}
+	return r ^ this.p[17], l ^ this.p[16]
+}
+
+// Read the next 32 bits of data, cycling round to the start
+func streamWord(data []byte, position *int) uint32 {
+	var word uint32
+	for i := 0; i < 4; i++ {
+		word = word<<8 | uint32(data[*position])
+		*position = (*position + 1) % len(data)
+	}
+	return word
+}
+
+// Mix the key into the subkeys, and the salt too if there is one. This is
+// Blowfish's key schedule, with bcrypt's salt.
+func (this *blowfish) expandKey(key, salt []byte) {
+	position := 0
+	for i := range this.p {
+		this.p[i] ^= streamWord(key, &position)
+	}
+
+	var l, r uint32
+	position = 0
+	next := func() {
+		if salt != nil {
+			l ^= streamWord(salt, &position)
+			r ^= streamWord(salt, &position)
+		}
+		l, r = this.encrypt(l, r)
+	}
+
+	for i := 0; i < len(this.p); i += 2 {
+		next()
+		this.p[i], this.p[i+1] = l, r
+	}
+	for i := range this.s {
+		for j := 0; j < 256; j += 2 {
+			next()
+			this.s[i][j], this.s[i][j+1] = l, r
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package mango
+
+import (
+	"testing"
+)
+
+func TestBcryptMatches(t *testing.T) {
+	// From OpenBSD's tests, and golang.org/x/crypto/bcrypt
+	hashes := map[string]string{
+		"U*U":    "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"":       "$2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s.",
+		"secret": "$2a$05$uaiyT9A3RDIyk4hILP4GmuSEX.4NKLvWRWnaefQtrOpRSWyT2BChe",
+	}
+	for password, hash := range hashes {
+		if ok, err := bcryptMatches(hash, password); !ok || err != nil {
+			t.Error("Expected", password, "to match:", hash, "got:", ok, err)
+		}
+		if ok, _ := bcryptMatches(hash, password+"x"); ok {
+			t.Error("Expected", password+"x", "not to match:", hash)
+		}
+	}
+
+	// htpasswd -B writes $2y$, which is the same algorithm
+	if ok, _ := bcryptMatches("$2y$05$uaiyT9A3RDIyk4hILP4GmuSEX.4NKLvWRWnaefQtrOpRSWyT2BChe", "secret"); !ok {
+		t.Error("Expected $2y$ hashes to match")
+	}
+
+	for _, hash := range []string{"$2y$05$fake", "$2x$05$uaiyT9A3RDIyk4hILP4GmuSEX.4NKLvWRWnaefQtrOpRSWyT2BChe", "$2a$99$uaiyT9A3RDIyk4hILP4GmuSEX.4NKLvWRWnaefQtrOpRSWyT2BChe"} {
+		if _, err := bcryptMatches(hash, "secret"); err != errBcryptHash {
+			t.Error("Expected a malformed hash error for", hash, "got:", err)
+		}
+	}
+}
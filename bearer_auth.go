@@ -0,0 +1,240 @@
+package mango
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// A key to verify JWTs with. The key's type decides which algorithm it
+// accepts: []byte for HS256, *rsa.PublicKey for RS256, and
+// ed25519.PublicKey for EdDSA.
+type JWTKey struct {
+	// If set, only tokens with this "kid" use the key
+	ID  string
+	Key interface{}
+}
+
+type BearerAuthOptions struct {
+	// The realm sent to clients. Defaults to "Bearer".
+	Realm string
+	// The keys tokens may be signed with
+	Keys []JWTKey
+	// If set, tokens must have this "aud" and "iss"
+	Audience string
+	Issuer   string
+	// How far clocks may differ when checking "exp" and "nbf"
+	Leeway time.Duration
+	// If set, tokens are also read from the cookie or query parameter with
+	// this name, when there's no Authorization header.
+	Cookie string
+	Query  string
+	// If nil, a default failure page will be used. The reason is available
+	// from env.AuthError().
+	Failure func(Env) (Status, Headers, Body)
+}
+
+var (
+	errNoBearerToken    = errors.New("no bearer token")
+	errMalformedJWT     = errors.New("malformed token")
+	errJWTSignature     = errors.New("invalid signature")
+	errJWTExpired       = errors.New("token expired")
+	errJWTNotYetValid   = errors.New("token not yet valid")
+	errJWTWrongAudience = errors.New("wrong audience")
+	errJWTWrongIssuer   = errors.New("wrong issuer")
+)
+
+// Why the auth middleware rejected the request, if it did
+func (this Env) AuthError() error {
+	err, _ := this["mango.auth_error"].(error)
+	return err
+}
+
+func bearerAuthFailure(options *BearerAuthOptions, err error) (Status, Headers, Body) {
+	realm := options.Realm
+	if realm == "" {
+		realm = "Bearer"
+	}
+	challenge := "Bearer realm=" + quoteAuthParam(realm)
+	if err != errNoBearerToken {
+		challenge += ", error=\"invalid_token\", error_description=" + quoteAuthParam(err.Error())
+	}
+	return 401, Headers{"WWW-Authenticate": []string{challenge}, "Content-Type": []string{"text/html"}}, Body("Access Denied.")
+}
+
+// Authenticate requests with a JWT bearer token. The token's claims are
+// available from env.User(), with "sub" as the Name, and "roles" as the
+// Roles.
+func BearerAuth(options *BearerAuthOptions) Middleware {
+	return func(env Env, app App) (Status, Headers, Body) {
+		delete(env, "mango.user")
+		delete(env, "mango.auth_error")
+
+		claims, err := verifyJWT(bearerToken(env.Request(), options), options, time.Now())
+		if err == nil {
+			env["mango.user"] = jwtUser(claims)
+			return app(env)
+		}
+
+		env["mango.auth_error"] = err
+		if options.Failure == nil {
+			return bearerAuthFailure(options, err)
+		}
+		return options.Failure(env)
+	}
+}
+
+// Find the token in the Authorization header, cookie or query
+func bearerToken(req *Request, options *BearerAuthOptions) string {
+	split := strings.SplitN(strings.TrimSpace(req.Header.Get("Authorization")), " ", 2)
+	if len(split) == 2 && strings.EqualFold(split[0], "Bearer") {
+		return strings.TrimSpace(split[1])
+	}
+	if options.Cookie != "" {
+		if cookie, err := req.Cookie(options.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	if options.Query != "" {
+		return req.URL.Query().Get(options.Query)
+	}
+	return ""
+}
+
+func jwtUser(claims map[string]interface{}) *User {
+	user := &User{Claims: claims}
+	user.Name, _ = claims["sub"].(string)
+	roles, _ := claims["roles"].([]interface{})
+	for _, role := range roles {
+		if role, ok := role.(string); ok {
+			user.Roles = append(user.Roles, role)
+		}
+	}
+	return user
+}
+
+// Check a JWT's signature and claims, returning the claims
+func verifyJWT(token string, options *BearerAuthOptions, now time.Time) (map[string]interface{}, error) {
+	if token == "" {
+		return nil, errNoBearerToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedJWT
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errMalformedJWT
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedJWT
+	}
+	if !verifyJWTSignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature, options.Keys) {
+		return nil, errJWTSignature
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errMalformedJWT
+	}
+	exp, hasExp, err := jwtTime(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if hasExp && now.After(exp.Add(options.Leeway)) {
+		return nil, errJWTExpired
+	}
+	nbf, hasNbf, err := jwtTime(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if hasNbf && now.Add(options.Leeway).Before(nbf) {
+		return nil, errJWTNotYetValid
+	}
+	if options.Audience != "" && !jwtHasAudience(claims["aud"], options.Audience) {
+		return nil, errJWTWrongAudience
+	}
+	if options.Issuer != "" && claims["iss"] != options.Issuer {
+		return nil, errJWTWrongIssuer
+	}
+	return claims, nil
+}
+
+// Read a time claim. It's optional, but if it's there it must be a number,
+// or the token is malformed.
+func jwtTime(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, errMalformedJWT
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+func decodeJWTPart(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Try each key which can make the token's algorithm
+func verifyJWTSignature(alg, kid, input string, signature []byte, keys []JWTKey) bool {
+	digest := sha256.Sum256([]byte(input))
+	for _, key := range keys {
+		if key.ID != "" && key.ID != kid {
+			continue
+		}
+		switch key := key.Key.(type) {
+		case []byte:
+			if alg != "HS256" {
+				continue
+			}
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(input))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		case ed25519.PublicKey:
+			if alg == "EdDSA" && ed25519.Verify(key, []byte(input), signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// "aud" can be a string or a list of them
+func jwtHasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
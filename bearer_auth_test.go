@@ -0,0 +1,151 @@
+package mango
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Make a JWT, signing it with the key
+func signJWT(alg string, key interface{}, header, claims map[string]interface{}) string {
+	header["alg"] = alg
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(input))
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("my_secret")
+
+	options := &BearerAuthOptions{
+		Keys: []JWTKey{
+			{Key: secret},
+			{ID: "rsa", Key: &rsaKey.PublicKey},
+			{Key: edPublic},
+		},
+		Audience: "my_api",
+		Issuer:   "my_issuer",
+		Leeway:   time.Minute,
+	}
+	now := time.Unix(1000000, 0)
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "foo", "aud": "my_api", "iss": "my_issuer", "exp": 1000100}
+		for key, value := range extra {
+			c[key] = value
+		}
+		return c
+	}
+
+	valid := map[string]string{
+		"HS256":         signJWT("HS256", secret, map[string]interface{}{}, claims(nil)),
+		"RS256":         signJWT("RS256", rsaKey, map[string]interface{}{"kid": "rsa"}, claims(nil)),
+		"EdDSA":         signJWT("EdDSA", edPrivate, map[string]interface{}{}, claims(nil)),
+		"audience list": signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"aud": []string{"other", "my_api"}})),
+		"within leeway": signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"exp": 999990, "nbf": 1000010})),
+	}
+	for name, token := range valid {
+		if result, err := verifyJWT(token, options, now); err != nil || result["sub"] != "foo" {
+			t.Error("Expected", name, "token to be valid, got:", err)
+		}
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	invalid := map[string]string{
+		"empty":           "",
+		"malformed":       "not.a-token",
+		"wrong secret":    signJWT("HS256", []byte("wrong"), map[string]interface{}{}, claims(nil)),
+		"wrong kid":       signJWT("RS256", rsaKey, map[string]interface{}{"kid": "other"}, claims(nil)),
+		"wrong RSA key":   signJWT("RS256", otherKey, map[string]interface{}{"kid": "rsa"}, claims(nil)),
+		"none":            strings.TrimSuffix(signJWT("none", nil, map[string]interface{}{}, claims(nil)), "."),
+		"wrong algorithm": signJWT("HS384", secret, map[string]interface{}{}, claims(nil)),
+		"expired":         signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"exp": 999900})),
+		"not yet valid":   signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"nbf": 1000100})),
+		"wrong audience":  signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"aud": "other"})),
+		"no audience":     signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"aud": nil})),
+		"wrong issuer":    signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"iss": "other"})),
+		"string exp":      signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"exp": "1"})),
+		"string nbf":      signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"nbf": "1"})),
+		"null exp":        signJWT("HS256", secret, map[string]interface{}{}, claims(map[string]interface{}{"exp": nil})),
+	}
+	for name, token := range invalid {
+		if _, err := verifyJWT(token, options, now); err == nil {
+			t.Error("Expected", name, "token to be invalid")
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	secret := []byte("my_secret")
+	userPage := func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body(env.User().Name + " " + strings.Join(env.User().Roles, ","))
+	}
+
+	bearerStack := new(Stack)
+	bearerStack.Middleware(BearerAuth(&BearerAuthOptions{Realm: "api", Keys: []JWTKey{{Key: secret}}, Cookie: "token", Query: "access_token"}))
+	bearerApp := bearerStack.Compile(userPage)
+
+	token := signJWT("HS256", secret, map[string]interface{}{}, map[string]interface{}{"sub": "foo", "roles": []string{"admin"}})
+
+	requests := map[string]func(*http.Request){
+		"header": func(request *http.Request) { request.Header.Set("Authorization", "bearer "+token) },
+		"cookie": func(request *http.Request) { request.AddCookie(&http.Cookie{Name: "token", Value: token}) },
+		"query":  func(request *http.Request) { request.URL.RawQuery = "access_token=" + token },
+	}
+	for name, setToken := range requests {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		setToken(request)
+		status, _, body := bearerApp(Env{"mango.request": &Request{request}})
+
+		if status != 200 || string(body) != "foo admin" {
+			t.Error("Expected the token in the", name, "to be accepted, got:", status, string(body))
+		}
+	}
+
+	// Without a token
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	status, headers, _ := bearerApp(Env{"mango.request": &Request{request}})
+
+	expected := `Bearer realm="api"`
+	if status != 401 || headers["WWW-Authenticate"][0] != expected {
+		t.Error("Expected WWW-Authenticate:", expected, "got:", status, headers["WWW-Authenticate"])
+	}
+
+	// With a bad token
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.Header.Set("Authorization", "Bearer "+token+"x")
+	env := Env{"mango.request": &Request{request}}
+	status, headers, _ = bearerApp(env)
+
+	expected = `Bearer realm="api", error="invalid_token", error_description="invalid signature"`
+	if status != 401 || headers["WWW-Authenticate"][0] != expected {
+		t.Error("Expected WWW-Authenticate:", expected, "got:", status, headers["WWW-Authenticate"])
+	}
+	if env.AuthError() != errJWTSignature {
+		t.Error("Expected the auth error:", errJWTSignature, "got:", env.AuthError())
+	}
+}
@@ -0,0 +1,148 @@
+package mango
+
+import (
+	"crypto/subtle"
+)
+
+// The session key the CSRF token is kept under
+const csrfSessionKey = "mango.csrf_token"
+
+type CSRFOptions struct {
+	// The form field and header the token is sent in. Default to
+	// "csrf_token" and "X-CSRF-Token".
+	Field  string
+	Header string
+	// If set, the token is kept in a cookie with this name instead of the
+	// session (double-submit cookie), so the Sessions middleware isn't
+	// needed. The cookie is signed with Secret, which is then required, so
+	// a cookie planted by someone else, e.g. from a sibling subdomain,
+	// isn't accepted.
+	Cookie        string
+	CookieOptions *CookieOptions
+	Secret        string
+	// Called when a request's token is missing or wrong. Defaults to a 403.
+	Failure func(Env) (Status, Headers, Body)
+}
+
+// The token to put in forms, for the CSRF middleware to check
+//
+//	<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+func (this Env) CSRFToken() string {
+	if token, ok := this["mango.csrf_token"].(string); ok {
+		return token
+	}
+	// Read from the session, so it's current if the session is regenerated
+	session, _ := this["mango.session"].(map[string]interface{})
+	token, _ := session[csrfSessionKey].(string)
+	return token
+}
+
+// Replace the CSRF token in the session, if there is one
+func rotateCSRFToken(session map[string]interface{}) {
+	if _, ok := session[csrfSessionKey]; !ok {
+		return
+	}
+	token, err := newSessionID()
+	if err != nil {
+		// The CSRF middleware makes a new one on the next request
+		delete(session, csrfSessionKey)
+		return
+	}
+	session[csrfSessionKey] = token
+}
+
+// Requests with unsafe methods (anything but GET, HEAD, OPTIONS and TRACE)
+// must send the token from env.CSRFToken() in a form field or header. By
+// default the token is kept in the session, so this must come after the
+// Sessions middleware.
+func CSRF(options *CSRFOptions) Middleware {
+	if options == nil {
+		options = new(CSRFOptions)
+	}
+	field := options.Field
+	if field == "" {
+		field = "csrf_token"
+	}
+	header := options.Header
+	if header == "" {
+		header = "X-CSRF-Token"
+	}
+	cookieOptions := options.CookieOptions
+	if cookieOptions == nil {
+		cookieOptions = &CookieOptions{Path: "/"}
+	}
+	if options.Cookie != "" {
+		if options.Secret == "" {
+			panic("mango: CSRF cookie " + options.Cookie + " needs a Secret")
+		}
+		if err := cookieOptions.validate(options.Cookie); err != nil {
+			panic(err)
+		}
+	}
+
+	return func(env Env, app App) (status Status, headers Headers, body Body) {
+		delete(env, "mango.csrf_token")
+
+		var token string
+		if options.Cookie != "" {
+			// Unsigned or tampered cookies are replaced
+			var ok bool
+			if token, ok = unsignCookie(sessionCookieValue(env, options.Cookie), options.Secret); !ok {
+				token = ""
+			}
+		} else {
+			token, _ = env.Session()[csrfSessionKey].(string)
+		}
+
+		if !csrfSafeMethod(env.Request().Method) && !csrfValid(env.Request(), token, field, header) {
+			if options.Failure == nil {
+				return forbidden()
+			}
+			return options.Failure(env)
+		}
+
+		created := token == ""
+		if created {
+			var err error
+			if token, err = newSessionID(); err != nil {
+				env.Logger().Println("Error creating CSRF token:", err)
+				return 500, Headers{"Content-Type": []string{"text/html"}}, Body("Internal Server Error")
+			}
+			if options.Cookie == "" {
+				env.Session()[csrfSessionKey] = token
+			}
+		}
+		if options.Cookie != "" {
+			env["mango.csrf_token"] = token
+		}
+
+		status, headers, body = app(env)
+		if created && options.Cookie != "" {
+			if headers == nil {
+				headers = Headers{}
+			}
+			commitSession(headers, env, options.Cookie, options.Secret, signCookie(token, options.Secret), cookieOptions)
+		}
+		return
+	}
+}
+
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// Check the request sent the token, in the header or the form
+func csrfValid(req *Request, token, field, header string) bool {
+	if token == "" {
+		return false
+	}
+	sent := req.Header.Get(header)
+	if sent == "" {
+		sent = req.PostFormValue(field)
+	}
+	return subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
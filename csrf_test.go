@@ -0,0 +1,163 @@
+package mango
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func csrfTestServer(env Env) (Status, Headers, Body) {
+	return 200, Headers{}, Body(env.CSRFToken())
+}
+
+func TestCSRF(t *testing.T) {
+	// Compile the stack
+	csrfStack := new(Stack)
+	csrfStack.Middleware(Sessions("my_secret", "my_key", &CookieOptions{}), CSRF(nil))
+	csrfApp := csrfStack.Compile(csrfTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	status, headers, body := csrfApp(Env{"mango.request": &Request{request}})
+	token := string(body)
+	if status != 200 || token == "" {
+		t.Fatal("Expected a CSRF token, got:", status, token)
+	}
+	cookie := responseCookie(headers, "my_key")
+	if cookie == nil {
+		t.Fatal("Expected the Set-Cookie header to be set")
+	}
+
+	post := func(form url.Values, header string) Status {
+		request, _ := http.NewRequest("POST", "http://localhost:3000/", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			request.Header.Set("X-CSRF-Token", header)
+		}
+		request.AddCookie(cookie)
+		status, _, _ := csrfApp(Env{"mango.request": &Request{request}})
+		return status
+	}
+
+	if status := post(url.Values{}, ""); status != 403 {
+		t.Error("Expected a POST without a token to get status: 403, got:", status)
+	}
+	if status := post(url.Values{"csrf_token": {"wrong"}}, ""); status != 403 {
+		t.Error("Expected a POST with the wrong token to get status: 403, got:", status)
+	}
+	if status := post(url.Values{"csrf_token": {token}}, ""); status != 200 {
+		t.Error("Expected a POST with the token in the form to get status: 200, got:", status)
+	}
+	if status := post(url.Values{}, token); status != 200 {
+		t.Error("Expected a POST with the token in the header to get status: 200, got:", status)
+	}
+}
+
+func TestCSRFDoubleSubmitCookie(t *testing.T) {
+	// Compile the stack
+	csrfStack := new(Stack)
+	csrfStack.Middleware(CSRF(&CSRFOptions{
+		Cookie: "csrf",
+		Secret: "my_secret",
+		Failure: func(env Env) (Status, Headers, Body) {
+			return 400, Headers{}, Body("Bad token")
+		},
+	}))
+	csrfApp := csrfStack.Compile(csrfTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, headers, body := csrfApp(Env{"mango.request": &Request{request}})
+	token := string(body)
+	cookie := responseCookie(headers, "csrf")
+	if cookie == nil {
+		t.Fatal("Expected the CSRF cookie to be set")
+	}
+	if data, ok := unsignCookie(cookie.Value, "my_secret"); !ok || data != token {
+		t.Fatal("Expected the CSRF cookie to hold the signed token:", token, "got:", cookie.Value)
+	}
+
+	request, _ = http.NewRequest("DELETE", "http://localhost:3000/", nil)
+	request.AddCookie(cookie)
+	status, _, body := csrfApp(Env{"mango.request": &Request{request}})
+	if status != 400 || string(body) != "Bad token" {
+		t.Error("Expected the failure page, got:", status, string(body))
+	}
+
+	request, _ = http.NewRequest("DELETE", "http://localhost:3000/", nil)
+	request.Header.Set("X-CSRF-Token", token)
+	request.AddCookie(cookie)
+	status, headers, _ = csrfApp(Env{"mango.request": &Request{request}})
+	if status != 200 {
+		t.Error("Expected status: 200, got:", status)
+	}
+	if cookie := responseCookie(headers, "csrf"); cookie != nil {
+		t.Error("Expected the CSRF cookie not to be set again, got:", cookie)
+	}
+
+	// A planted cookie, which isn't signed with the secret, isn't accepted
+	for _, planted := range []string{"attacker_token", signCookie("attacker_token", "other_secret")} {
+		request, _ = http.NewRequest("DELETE", "http://localhost:3000/", nil)
+		request.Header.Set("X-CSRF-Token", "attacker_token")
+		request.AddCookie(&http.Cookie{Name: "csrf", Value: planted})
+		status, _, _ = csrfApp(Env{"mango.request": &Request{request}})
+		if status != 400 {
+			t.Error("Expected a planted cookie to be rejected, got:", status)
+		}
+	}
+
+	// The secret is required
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected CSRF to panic without a Secret")
+		}
+	}()
+	CSRF(&CSRFOptions{Cookie: "csrf"})
+}
+
+func TestCSRFRegenerateSession(t *testing.T) {
+	loginTestServer := func(env Env) (Status, Headers, Body) {
+		switch env.Request().URL.Path {
+		case "/login":
+			env.RegenerateSession()
+		case "/logout":
+			env.ResetSession()
+		}
+		return 200, Headers{}, Body(env.CSRFToken())
+	}
+
+	// Compile the stack
+	csrfStack := new(Stack)
+	csrfStack.Middleware(Sessions("my_secret", "my_key", &CookieOptions{}), CSRF(nil))
+	csrfApp := csrfStack.Compile(loginTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, headers, body := csrfApp(Env{"mango.request": &Request{request}})
+	before := string(body)
+	cookie := responseCookie(headers, "my_key")
+
+	// The token changes at login, and the page gets the new one
+	form := url.Values{"csrf_token": {before}}
+	request, _ = http.NewRequest("POST", "http://localhost:3000/login", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(cookie)
+	_, headers, body = csrfApp(Env{"mango.request": &Request{request}})
+	after := string(body)
+
+	if after == "" || after == before {
+		t.Error("Expected a new CSRF token after login, got:", after)
+	}
+	cookie = responseCookie(headers, "my_key")
+	if session := decodeCookie(cookie.Value, "my_secret"); session[csrfSessionKey] != after {
+		t.Error("Expected the session to hold the new token:", after, "got:", session[csrfSessionKey])
+	}
+
+	// As at logout
+	request, _ = http.NewRequest("POST", "http://localhost:3000/logout", nil)
+	request.Header.Set("X-CSRF-Token", after)
+	request.AddCookie(cookie)
+	_, _, body = csrfApp(Env{"mango.request": &Request{request}})
+
+	if string(body) == "" || string(body) == after {
+		t.Error("Expected a new CSRF token after logout, got:", string(body))
+	}
+}
@@ -0,0 +1,267 @@
+package mango
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a nonce can be used for before clients must get a new one
+const digestNonceLifetime = 5 * time.Minute
+
+var (
+	errNoDigest          = errors.New("no digest credentials")
+	errMalformedDigest   = errors.New("malformed digest credentials")
+	errDigestAlgorithm   = errors.New("unsupported digest algorithm")
+	errDigestStaleNonce  = errors.New("stale nonce")
+	errDigestInvalid     = errors.New("invalid digest credentials")
+	errDigestNonceReused = errors.New("nonce count reused")
+)
+
+// Performs HTTP Digest Auth (RFC 7616) with qop=auth, using SHA-256 or
+// MD5. The password function returns the user's password, or false if
+// there's no such user. If failure is nil, a default failure page will be
+// used. Either way, the response asks for digest credentials.
+func DigestAuth(realm string, password func(string) (string, bool), failure func(Env) (Status, Headers, Body)) Middleware {
+	nonces := newDigestNonces()
+
+	return func(env Env, app App) (Status, Headers, Body) {
+		delete(env, "mango.user")
+		delete(env, "mango.auth_error")
+
+		username, err := checkDigest(env.Request(), realm, password, nonces, time.Now())
+		if err == nil {
+			env["mango.user"] = &User{Name: username}
+			return app(env)
+		}
+		env["mango.auth_error"] = err
+
+		var status Status
+		var headers Headers
+		var body Body
+		if failure == nil {
+			status, headers, body = 401, Headers{"Content-Type": []string{"text/html"}}, Body("Access Denied.")
+		} else {
+			status, headers, body = failure(env)
+			if headers == nil {
+				headers = Headers{}
+			}
+		}
+		if _, ok := headers["WWW-Authenticate"]; !ok {
+			headers["WWW-Authenticate"] = digestChallenges(realm, nonces.issue(time.Now()), err == errDigestStaleNonce)
+		}
+		return status, headers, body
+	}
+}
+
+// Ask for credentials, preferring SHA-256
+func digestChallenges(realm, nonce string, stale bool) []string {
+	var challenges []string
+	for _, algorithm := range []string{"SHA-256", "MD5"} {
+		challenge := fmt.Sprintf("Digest realm=%s, qop=\"auth\", algorithm=%s, nonce=\"%s\"", quoteAuthParam(realm), algorithm, nonce)
+		if stale {
+			challenge += ", stale=true"
+		}
+		challenges = append(challenges, challenge)
+	}
+	return challenges
+}
+
+// Check the digest credentials sent with the request, returning the user
+func checkDigest(req *Request, realm string, password func(string) (string, bool), nonces *digestNonces, now time.Time) (string, error) {
+	params, err := parseDigest(req.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToUpper(params["algorithm"]) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", errDigestAlgorithm
+	}
+	username, nonce, cnonce := params["username"], params["nonce"], params["cnonce"]
+	if username == "" || nonce == "" || cnonce == "" || params["qop"] != "auth" || params["userhash"] == "true" {
+		return "", errMalformedDigest
+	}
+	// The client signs the request line, which Mount leaves alone
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	if params["realm"] != realm || params["uri"] != uri {
+		return "", errDigestInvalid
+	}
+	count, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		return "", errMalformedDigest
+	}
+	if err := nonces.valid(nonce, now); err != nil {
+		return "", err
+	}
+
+	if password == nil { // fail auth by default if you use this middleware
+		return "", errDigestInvalid
+	}
+	pass, ok := password(username)
+	if !ok {
+		return "", errDigestInvalid
+	}
+	expected := digestResponse(newHash, username, realm, pass, req.Method, params["uri"], nonce, params["nc"], cnonce)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return "", errDigestInvalid
+	}
+
+	// Only count the nonce once we know the client has the password
+	if err := nonces.use(nonce, count, now); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// The response a client with the password sends, for qop=auth
+func digestResponse(newHash func() hash.Hash, username, realm, password, method, uri, nonce, nc, cnonce string) string {
+	h := func(data string) string {
+		sum := newHash()
+		sum.Write([]byte(data))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	ha1 := h(username + ":" + realm + ":" + password)
+	ha2 := h(method + ":" + uri)
+	return h(strings.Join([]string{ha1, nonce, nc, cnonce, "auth", ha2}, ":"))
+}
+
+// Parse the parameters of a Digest Authorization header
+func parseDigest(header string) (map[string]string, error) {
+	split := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(split) != 2 || !strings.EqualFold(split[0], "Digest") {
+		return nil, errNoDigest
+	}
+
+	params := make(map[string]string)
+	rest := strings.TrimSpace(split[1])
+	for rest != "" {
+		equals := strings.Index(rest, "=")
+		if equals < 0 {
+			return nil, errMalformedDigest
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:equals]))
+		rest = strings.TrimSpace(rest[equals+1:])
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			// Quoted string, with backslash escapes
+			var quoted strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				quoted.WriteByte(rest[i])
+			}
+			if i >= len(rest) {
+				return nil, errMalformedDigest
+			}
+			value = quoted.String()
+			rest = rest[i+1:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		params[name] = value
+
+		rest = strings.TrimSpace(rest)
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, errMalformedDigest
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	return params, nil
+}
+
+// Issues nonces, and tracks the counts used with them. Nonces carry their
+// own time and signature, so only nonces clients have authenticated with
+// are remembered.
+type digestNonces struct {
+	secret []byte
+	lock   sync.Mutex
+	counts map[string]uint64
+	swept  time.Time
+}
+
+func newDigestNonces() *digestNonces {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &digestNonces{secret: secret, counts: make(map[string]uint64)}
+}
+
+func (this *digestNonces) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, this.secret)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// A nonce is the time, random bytes, and a signature of both
+func (this *digestNonces) issue(now time.Time) string {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, uint64(now.Unix()))
+	rand.Read(data[8:])
+	return base64.RawURLEncoding.EncodeToString(append(data, this.sign(data)...))
+}
+
+// Check the nonce was issued by us, and hasn't expired
+func (this *digestNonces) valid(nonce string, now time.Time) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(decoded) != 32 || !hmac.Equal(decoded[16:], this.sign(decoded[:16])) {
+		return errDigestInvalid
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(decoded)), 0)
+	if now.After(issued.Add(digestNonceLifetime)) {
+		return errDigestStaleNonce
+	}
+	return nil
+}
+
+// Record a use of the nonce, which must have a higher count than the last
+func (this *digestNonces) use(nonce string, count uint64, now time.Time) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if now.After(this.swept.Add(digestNonceLifetime)) {
+		for n := range this.counts {
+			if this.valid(n, now) != nil {
+				delete(this.counts, n)
+			}
+		}
+		this.swept = now
+	}
+
+	if count <= this.counts[nonce] {
+		return errDigestNonceReused
+	}
+	this.counts[nonce] = count
+	return nil
+}
@@ -0,0 +1,167 @@
+package mango
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestResponse(t *testing.T) {
+	// The examples from RFC 7616
+	responses := map[string]func() hash.Hash{
+		"8ca523f5e9506fed4657c9700eebdbec":                                 md5.New,
+		"753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1": sha256.New,
+	}
+	for expected, newHash := range responses {
+		response := digestResponse(newHash, "Mufasa", "http-auth@example.org", "Circle of Life", "GET", "/dir/index.html",
+			"7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "00000001", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+		if response != expected {
+			t.Error("Expected response:", expected, "got:", response)
+		}
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	params, err := parseDigest(`digest username="Mu\"fasa", realm="a, b", nc=00000001, qop=auth`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"username": `Mu"fasa`, "realm": "a, b", "nc": "00000001", "qop": "auth"}
+	for name, value := range expected {
+		if params[name] != value {
+			t.Error("Expected", name, "to equal:", value, "got:", params[name])
+		}
+	}
+
+	for _, header := range []string{"", "Basic Zm9vOmZvbw==", `Digest username="foo`, `Digest username`} {
+		if _, err := parseDigest(header); err == nil {
+			t.Error("Expected an error for:", header)
+		}
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	password := func(username string) (string, bool) {
+		return "secret", username == "foo"
+	}
+
+	digestStack := new(Stack)
+	digestStack.Middleware(DigestAuth("my realm", password, nil))
+	digestApp := digestStack.Compile(successPage)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/things?a=1", nil)
+	status, headers, _ := digestApp(Env{"mango.request": &Request{request}})
+
+	challenges := headers["WWW-Authenticate"]
+	if status != 401 || len(challenges) != 2 || !strings.HasPrefix(challenges[0], `Digest realm="my realm", qop="auth", algorithm=SHA-256, nonce="`) {
+		t.Fatal("Expected digest challenges, got:", status, challenges)
+	}
+	nonce := strings.TrimSuffix(strings.SplitN(challenges[0], `nonce="`, 2)[1], `"`)
+
+	authorize := func(algorithm string, newHash func() hash.Hash, username, pass, nc string) Status {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/things?a=1", nil)
+		response := digestResponse(newHash, username, "my realm", pass, "GET", "/things?a=1", nonce, nc, "abc")
+		request.Header.Set("Authorization", fmt.Sprintf(`Digest username="%s", realm="my realm", nonce="%s", uri="/things?a=1", algorithm=%s, qop=auth, nc=%s, cnonce="abc", response="%s"`, username, nonce, algorithm, nc, response))
+		status, _, _ := digestApp(Env{"mango.request": &Request{request}})
+		return status
+	}
+
+	if status := authorize("SHA-256", sha256.New, "foo", "secret", "00000001"); status != 200 {
+		t.Error("Expected SHA-256 credentials to be accepted, got:", status)
+	}
+	if status := authorize("SHA-256", sha256.New, "foo", "secret", "00000001"); status != 401 {
+		t.Error("Expected a reused nonce count to be rejected, got:", status)
+	}
+	if status := authorize("MD5", md5.New, "foo", "secret", "00000002"); status != 200 {
+		t.Error("Expected MD5 credentials to be accepted, got:", status)
+	}
+	if status := authorize("SHA-256", sha256.New, "foo", "wrong", "00000003"); status != 401 {
+		t.Error("Expected the wrong password to be rejected, got:", status)
+	}
+	if status := authorize("SHA-256", sha256.New, "bar", "secret", "00000003"); status != 401 {
+		t.Error("Expected an unknown user to be rejected, got:", status)
+	}
+	// A failed attempt doesn't use up the count
+	if status := authorize("SHA-256", sha256.New, "foo", "secret", "00000003"); status != 200 {
+		t.Error("Expected SHA-256 credentials to be accepted, got:", status)
+	}
+}
+
+func TestDigestAuthMounted(t *testing.T) {
+	password := func(username string) (string, bool) {
+		return "secret", username == "foo"
+	}
+
+	inner := new(Stack)
+	inner.Middleware(DigestAuth("my realm", password, nil))
+	outer := new(Stack)
+	outer.Middleware(Mount("/admin", inner.Compile(successPage)))
+	digestApp := outer.Compile(successPage)
+
+	newRequest := func() *http.Request {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/admin/x", nil)
+		request.RequestURI = "/admin/x"
+		return request
+	}
+	_, headers, _ := digestApp(Env{"mango.request": &Request{newRequest()}})
+	nonce := strings.TrimSuffix(strings.SplitN(headers["WWW-Authenticate"][0], `nonce="`, 2)[1], `"`)
+
+	authorize := func(uri, nc string) Status {
+		request := newRequest()
+		response := digestResponse(sha256.New, "foo", "my realm", "secret", "GET", uri, nonce, nc, "abc")
+		request.Header.Set("Authorization", fmt.Sprintf(`Digest username="foo", realm="my realm", nonce="%s", uri="%s", algorithm=SHA-256, qop=auth, nc=%s, cnonce="abc", response="%s"`, nonce, uri, nc, response))
+		status, _, _ := digestApp(Env{"mango.request": &Request{request}})
+		return status
+	}
+
+	// The client signs the path it requested, prefix and all
+	if status := authorize("/admin/x", "00000001"); status != 200 {
+		t.Error("Expected credentials for the full path to be accepted, got:", status)
+	}
+	if status := authorize("/x", "00000002"); status != 401 {
+		t.Error("Expected credentials for the mounted path to be rejected, got:", status)
+	}
+}
+
+func TestDigestNonces(t *testing.T) {
+	nonces := newDigestNonces()
+	now := time.Now()
+	nonce := nonces.issue(now.Add(-2 * digestNonceLifetime))
+
+	if err := nonces.valid(nonce, now); err != errDigestStaleNonce {
+		t.Error("Expected an old nonce to be stale, got:", err)
+	}
+	if err := newDigestNonces().valid(nonces.issue(now), now); err != errDigestInvalid {
+		t.Error("Expected another middleware's nonce to be invalid, got:", err)
+	}
+
+	// Stale nonces are flagged in the challenge
+	if challenges := digestChallenges("my realm", nonce, true); !strings.HasSuffix(challenges[0], ", stale=true") {
+		t.Error("Expected the challenge to be stale, got:", challenges[0])
+	}
+}
+
+func TestDigestAuthFailure(t *testing.T) {
+	digestStack := new(Stack)
+	digestStack.Middleware(DigestAuth("my realm", nil, failurePage))
+	digestApp := digestStack.Compile(successPage)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	env := Env{"mango.request": &Request{request}}
+	status, headers, body := digestApp(env)
+
+	if status != 403 || string(body) != "auth failed" {
+		t.Error("Expected the failure page, got:", status, string(body))
+	}
+	if len(headers["WWW-Authenticate"]) != 2 {
+		t.Error("Expected the failure page to have digest challenges, got:", headers["WWW-Authenticate"])
+	}
+	if env.AuthError() != errNoDigest {
+		t.Error("Expected the auth error:", errNoDigest, "got:", env.AuthError())
+	}
+}
@@ -0,0 +1,56 @@
+package mango
+
+import (
+	"strings"
+)
+
+// Flashes are kept in the session under "mango.flash.<kind>"
+const flashPrefix = "mango.flash."
+
+// Add a one-shot message, e.g. to show after a redirect. It's kept in the
+// session until the next request, where it can be read with Flashes.
+// Needs the Sessions middleware.
+//
+//	env.AddFlash("notice", "Saved!")
+//	return mango.Redirect(302, "/")
+func (this Env) AddFlash(kind, message string) {
+	key := flashPrefix + kind
+	messages, _ := this.Session()[key].([]string)
+	this.Session()[key] = append(messages, message)
+}
+
+// Read the messages of a kind added during the previous request. They're
+// removed once read, and cleared at the end of this request either way.
+func (this Env) Flashes(kind string) []string {
+	flashes, _ := this["mango.flashes"].(map[string][]string)
+	messages := flashes[kind]
+	delete(flashes, kind)
+	return messages
+}
+
+// Move the flashes added during the previous request out of the session,
+// so they only last for this one.
+func prepareFlashes(env Env) {
+	flashes := make(map[string][]string)
+	session := env.Session()
+	for key, value := range session {
+		if !strings.HasPrefix(key, flashPrefix) {
+			continue
+		}
+		delete(session, key)
+
+		kind := strings.TrimPrefix(key, flashPrefix)
+		switch messages := value.(type) {
+		case []string:
+			flashes[kind] = messages
+		case []interface{}:
+			// Codecs such as JSON don't know the type of the slice
+			for _, message := range messages {
+				if message, ok := message.(string); ok {
+					flashes[kind] = append(flashes[kind], message)
+				}
+			}
+		}
+	}
+	env["mango.flashes"] = flashes
+}
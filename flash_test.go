@@ -0,0 +1,59 @@
+package mango
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFlash(t *testing.T) {
+	flashTestServer := func(env Env) (Status, Headers, Body) {
+		if env.Request().Method == "POST" {
+			env.AddFlash("notice", "Saved!")
+			env.AddFlash("notice", "Really saved!")
+			env.AddFlash("error", "Not quite")
+			return Redirect(302, "/")
+		}
+		return 200, Headers{}, Body(strings.Join(env.Flashes("notice"), " ") + "|" + strings.Join(env.Flashes("notice"), " "))
+	}
+
+	// Compile the stack
+	flashStack := new(Stack)
+	flashStack.Middleware(Sessions("my_secret", "my_key", &CookieOptions{}))
+	flashApp := flashStack.Compile(flashTestServer)
+
+	request, _ := http.NewRequest("POST", "http://localhost:3000/things", nil)
+	_, headers, _ := flashApp(Env{"mango.request": &Request{request}})
+	cookie := responseCookie(headers, "my_key")
+	if cookie == nil {
+		t.Fatal("Expected the Set-Cookie header to be set")
+	}
+
+	// Read after the redirect, and only once
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(cookie)
+	_, headers, body := flashApp(Env{"mango.request": &Request{request}})
+
+	expected := "Saved! Really saved!|"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+
+	// Flashes are cleared whether they were read or not
+	cookie = responseCookie(headers, "my_key")
+	if cookie == nil {
+		t.Fatal("Expected the Set-Cookie header to be set")
+	}
+	if session := decodeCookie(cookie.Value, "my_secret"); len(session) != 0 {
+		t.Error("Expected the session to be empty, got:", session)
+	}
+
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(cookie)
+	_, _, body = flashApp(Env{"mango.request": &Request{request}})
+
+	expected = "|"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
@@ -0,0 +1,218 @@
+package mango
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checks passwords against an Apache htpasswd file, for BasicAuth:
+//
+//	htpasswd, err := mango.NewHtpasswd("/etc/app/htpasswd")
+//	stack.Middleware(mango.BasicAuth(htpasswd.Auth, nil))
+//
+// bcrypt ("$2y$"), SHA1 ("{SHA}") and APR1-MD5 ("$apr1$") entries are
+// supported. The file is reloaded when it changes.
+type Htpasswd struct {
+	path    string
+	lock    sync.Mutex
+	users   map[string]string
+	dummy   string
+	modTime time.Time
+	size    int64
+}
+
+// Load the htpasswd file at path
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	htpasswd := &Htpasswd{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := htpasswd.load(info); err != nil {
+		return nil, err
+	}
+	return htpasswd, nil
+}
+
+// An auth function for BasicAuth
+func (this *Htpasswd) Auth(username, password string, req Request, err error) bool {
+	if err != nil {
+		return false
+	}
+	hash, ok := this.lookup(username)
+	if !ok {
+		// Take as long as a real user would, so timing doesn't reveal
+		// which usernames exist
+		this.check(hash, password)
+		return false
+	}
+	return this.check(hash, password)
+}
+
+// Find a user's password hash, reloading the file first if it's changed.
+// Unknown users get the dummy hash.
+func (this *Htpasswd) lookup(username string) (string, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	info, err := os.Stat(this.path)
+	if err != nil {
+		log.Println("Error reading htpasswd file:", err)
+	} else if !info.ModTime().Equal(this.modTime) || info.Size() != this.size {
+		if err := this.load(info); err != nil {
+			// Keep using the users we have
+			log.Println("Error reading htpasswd file:", err)
+		}
+	}
+
+	hash, ok := this.users[username]
+	if !ok {
+		return this.dummy, false
+	}
+	return hash, true
+}
+
+func (this *Htpasswd) load(info os.FileInfo) error {
+	file, err := os.Open(this.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	dummy := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			return errors.New("mango: malformed htpasswd line for " + split[0])
+		}
+		users[split[0]] = split[1]
+		// Check unknown users against the slowest kind of entry
+		if dummy == "" || isBcrypt(split[1]) && !isBcrypt(dummy) {
+			dummy = split[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	this.users = users
+	this.dummy = dummy
+	this.modTime = info.ModTime()
+	this.size = info.Size()
+	return nil
+}
+
+func (this *Htpasswd) check(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return constantTimeEqual(hash[len("{SHA}"):], base64.StdEncoding.EncodeToString(sum[:]))
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		return constantTimeEqual(hash, apr1(password, salt))
+	case isBcrypt(hash):
+		ok, err := bcryptMatches(hash, password)
+		if err != nil {
+			log.Println("Error checking htpasswd password:", err)
+		}
+		return ok
+	}
+	// Plain text and crypt(3) entries aren't supported
+	return false
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// The alphabet crypt(3) uses for base 64
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Hash a password the way Apache's APR1-MD5 does, returning the whole
+// "$apr1$salt$hash" entry
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.New()
+	alternate.Write(pw)
+	alternate.Write([]byte(salt))
+	alternate.Write(pw)
+	alt := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alt)
+		} else {
+			ctx.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	// Slow it down
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	result := []byte(magic + salt + "$")
+	encode := func(value uint, n int) {
+		for ; n > 0; n-- {
+			result = append(result, cryptAlphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[i[0]])<<16|uint(final[i[1]])<<8|uint(final[i[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+	return string(result)
+}
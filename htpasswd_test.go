@@ -0,0 +1,106 @@
+package mango
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApr1(t *testing.T) {
+	// Made with `openssl passwd -apr1`
+	hashes := map[string]string{
+		"secret":                              "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/",
+		"a much longer password than sixteen": "$apr1$xy12$wNX6XSSVgNkXUp5CplTmH1",
+	}
+	for password, expected := range hashes {
+		salt := expected[len("$apr1$") : len(expected)-23]
+		if hash := apr1(password, salt); hash != expected {
+			t.Error("Expected hash of", password, "to equal:", expected, "got:", hash)
+		}
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mango")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "htpasswd")
+	ioutil.WriteFile(path, []byte("# users\nsha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\napr:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/\nbcrypt:$2a$05$uaiyT9A3RDIyk4hILP4GmuSEX.4NKLvWRWnaefQtrOpRSWyT2BChe\nplain:secret\n"), 0600)
+
+	htpasswd, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if htpasswd.dummy != htpasswd.users["bcrypt"] {
+		t.Error("Expected unknown users to be checked against the bcrypt entry, got:", htpasswd.dummy)
+	}
+
+	check := func(username, password string) bool {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		return htpasswd.Auth(username, password, Request{request}, nil)
+	}
+
+	for _, username := range []string{"sha", "apr", "bcrypt"} {
+		if !check(username, "secret") {
+			t.Error("Expected", username, "to be accepted")
+		}
+		if check(username, "wrong") {
+			t.Error("Expected", username, "with the wrong password to be rejected")
+		}
+	}
+	if check("plain", "secret") {
+		t.Error("Expected plain text passwords to be rejected")
+	}
+	if check("nobody", "secret") {
+		t.Error("Expected unknown users to be rejected")
+	}
+
+	// Changes to the file are picked up
+	ioutil.WriteFile(path, []byte("new:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if !check("new", "secret") {
+		t.Error("Expected the reloaded file's users to be accepted")
+	}
+	if check("sha", "secret") {
+		t.Error("Expected users removed from the file to be rejected")
+	}
+}
+
+func TestHtpasswdBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mango")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "htpasswd")
+	ioutil.WriteFile(path, []byte("foo:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600)
+	htpasswd, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basicAuthStack := new(Stack)
+	basicAuthStack.Middleware(BasicAuth(htpasswd.Auth, nil))
+	basicAuthApp := basicAuthStack.Compile(successPage)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.SetBasicAuth("foo", "secret")
+	status, _, _ := basicAuthApp(Env{"mango.request": &Request{request}})
+
+	if status != 200 {
+		t.Error("Expected status: 200, got:", status)
+	}
+
+	if _, err := NewHtpasswd(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error loading a missing file")
+	}
+}
@@ -2,7 +2,9 @@ package mango
 
 import (
 	"fmt"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +23,13 @@ func JSONP(env Env, app App) (Status, Headers, Body) {
 
 	if callback != "" && strings.Contains(headers.Get("Content-Type"), "application/json") {
 		headers.Set("Content-Type", strings.Replace(headers.Get("Content-Type"), "json", "javascript", -1))
+		if stream := env.Stream(); stream != nil {
+			env.SetStream(jsonpStream(callback, stream))
+			if length, err := strconv.Atoi(headers.Get("Content-Length")); err == nil {
+				headers.Set("Content-Length", fmt.Sprintf("%d", length+len(callback)+2))
+			}
+			return status, headers, body
+		}
 		body = Body(fmt.Sprintf("%s(%s)", callback, body))
 		if headers.Get("Content-Length") != "" {
 			headers.Set("Content-Length", fmt.Sprintf("%d", len(body)))
@@ -29,3 +38,17 @@ func JSONP(env Env, app App) (Status, Headers, Body) {
 
 	return status, headers, body
 }
+
+// Wrap a streamed body in the callback function
+func jsonpStream(callback string, stream Stream) Stream {
+	return func(w io.Writer) error {
+		if _, err := io.WriteString(w, callback+"("); err != nil {
+			return err
+		}
+		if err := stream(w); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, ")")
+		return err
+	}
+}
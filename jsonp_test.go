@@ -1,7 +1,9 @@
 package mango
 
 import (
+	"bytes"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -204,3 +206,46 @@ func BenchmarkJSONPInvalidCallback(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func TestJSONPStreaming(t *testing.T) {
+	jsonStreamServer := func(env Env) (Status, Headers, Body) {
+		headers := Headers{"Content-Type": []string{"application/json"}, "Content-Length": []string{"13"}}
+		return Streaming(env, 200, headers, ReaderStream(strings.NewReader("{\"foo\":\"bar\"}")))
+	}
+
+	// Compile the stack
+	jsonpStack := new(Stack)
+	jsonpStack.Middleware(JSONP)
+	jsonpApp := jsonpStack.Compile(jsonStreamServer)
+
+	// Request against it
+	request, err := http.NewRequest("GET", "http://localhost:3000/?callback=parseResponse", nil)
+	env := Env{"mango.request": &Request{request}}
+	status, headers, _ := jsonpApp(env)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if status != 200 {
+		t.Error("Expected status to equal 200, got:", status)
+	}
+
+	if headers.Get("Content-Type") != "application/javascript" {
+		t.Error("Expected Content-Type to equal \"application/javascript\", got:", headers.Get("Content-Type"))
+	}
+
+	if headers.Get("Content-Length") != "28" {
+		t.Error("Expected Content-Length to equal \"28\", got:", headers.Get("Content-Length"))
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := env.Stream()(buffer); err != nil {
+		t.Error(err)
+	}
+
+	expected := "parseResponse({\"foo\":\"bar\"})"
+	if buffer.String() != expected {
+		t.Error("Expected body:", buffer.String(), "to equal:", expected)
+	}
+}
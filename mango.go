@@ -2,11 +2,16 @@
 package mango
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/textproto"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 type Request struct {
@@ -16,6 +21,13 @@ type Request struct {
 type Status int
 type Body string
 
+// A Stream writes a response body directly to the client, a piece at a
+// time. Apps which produce large or incremental responses can set one
+// with Env.SetStream, and it will be used in place of the returned Body.
+// Streams should release anything they hold, such as open files, even when
+// writing fails.
+type Stream func(io.Writer) error
+
 type Headers http.Header
 
 func (h Headers) Add(key, value string) {
@@ -48,10 +60,141 @@ func (this Env) Request() *Request {
 	return this["mango.request"].(*Request)
 }
 
+// The context for this request. It is cancelled when the client goes away
+// or the server shuts down, and carries any deadlines or values set by
+// middleware further down the stack.
+func (this Env) Context() context.Context {
+	if ctx, ok := this["mango.context"].(context.Context); ok {
+		return ctx
+	}
+	if request, ok := this["mango.request"].(*Request); ok && request.Request != nil {
+		return request.Context()
+	}
+	return context.Background()
+}
+
+// Replace the context for this request, e.g. with one derived from
+// Env.Context() carrying a deadline. As with other middleware the Env is
+// changed in place, and is returned for convenience:
+//
+//	ctx, cancel := context.WithTimeout(env.Context(), time.Second)
+//	defer cancel()
+//	return app(env.WithContext(ctx))
+func (this Env) WithContext(ctx context.Context) Env {
+	this["mango.context"] = ctx
+	if request, ok := this["mango.request"].(*Request); ok && request.Request != nil {
+		this["mango.request"] = &Request{request.WithContext(ctx)}
+	}
+	return this
+}
+
+// The named parameters captured by Routing or a Router, from patterns
+// like "/users/:id" or "/users/(?P<id>[0-9]+)"
+func (this Env) Params() map[string]string {
+	params, _ := this["mango.params"].(map[string]string)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	return params
+}
+
+func (this Env) Param(name string) string {
+	return this.Params()[name]
+}
+
+func (this Env) ParamInt(name string) (int, error) {
+	value, ok := this.Params()[name]
+	if !ok {
+		return 0, fmt.Errorf("mango: no param %q", name)
+	}
+	return strconv.Atoi(value)
+}
+
+// Build the path for a named route, from the Routers this request has
+// passed through. See Router.URLFor.
+func (this Env) URLFor(name string, params map[string]string) (string, error) {
+	routers, _ := this["mango.routers"].([]mountedRouter)
+	return urlFor(routers, name, params)
+}
+
+// The path prefix the current app is mounted at with Mount, like
+// SCRIPT_NAME in Rack. It's "" for apps which aren't mounted.
+func (this Env) BasePath() string {
+	base, _ := this["mango.base_path"].(string)
+	return base
+}
+
 func (this Env) Session() map[string]interface{} {
 	return this["mango.session"].(map[string]interface{})
 }
 
+// The error from decoding the session sent with this request, if any. The
+// session starts empty when it can't be decoded.
+func (this Env) SessionError() error {
+	err, _ := this["mango.session_error"].(error)
+	return err
+}
+
+// Give the session a new ID, keeping its data, e.g. at login to prevent
+// session fixation. The old CSRF token stops working. With a
+// SessionOptions Store, the old ID is deleted, so it stops working too.
+// Without one there's no ID to revoke: the old cookie still decodes until
+// AbsoluteTimeout or IdleTimeout runs out. Needs the Sessions middleware.
+func (this Env) RegenerateSession() {
+	this["mango.session_regenerate"] = true
+	rotateCSRFToken(this.Session())
+}
+
+// Empty the session and give it a new ID, e.g. at logout. As with
+// RegenerateSession, only a Store revokes the old session; a cookie-only
+// session sent again is accepted until it times out. Needs the Sessions
+// middleware.
+func (this Env) ResetSession() {
+	// Empty the map in place, so anything holding it sees the change
+	session := this.Session()
+	_, csrf := session[csrfSessionKey]
+	for key := range session {
+		delete(session, key)
+	}
+	if csrf {
+		// Keep a CSRF token for forms built during this request
+		session[csrfSessionKey] = ""
+	}
+	this.RegenerateSession()
+}
+
+func (this Env) Stream() Stream {
+	stream, _ := this["mango.stream"].(Stream)
+	return stream
+}
+
+// Set the Stream used for the response body. Passing nil removes it,
+// so the returned Body is sent instead.
+func (this Env) SetStream(stream Stream) {
+	if stream == nil {
+		delete(this, "mango.stream")
+		return
+	}
+	this["mango.stream"] = stream
+}
+
+// Remove the Stream without sending it. It's run into a writer which
+// refuses every write, so it stops at its first write and releases anything
+// it holds, such as the reader of a ReaderStream.
+func (this Env) DiscardStream() {
+	stream := this.Stream()
+	if stream == nil {
+		return
+	}
+	this.SetStream(nil)
+
+	// Nobody is waiting for it, so don't let it panic
+	defer func() {
+		recover()
+	}()
+	stream(discardWriter{})
+}
+
 // This is the core app the user has written
 type App func(Env) (Status, Headers, Body)
 
@@ -96,9 +239,24 @@ func middlewareify(app App) Middleware {
 }
 
 type Stack struct {
-	Address    string
+	Address string
+
+	// Server is used by Run, RunTLS and Serve, so its timeouts, header
+	// limits and TLS config apply. Its Handler is replaced with the stack.
+	// If nil, a default http.Server is used.
+	Server *http.Server
+
 	middleware []Middleware
 	app        App
+	routers    []mountedRouter
+	server     *http.Server
+	drained    chan struct{}
+	lock       sync.Mutex
+	// The App ServeHTTP uses, or a nil App once the middleware changes.
+	// Read without the lock, so serving requests doesn't contend on it.
+	compiled atomic.Value
+	// Set by Shutdown, so a server which hasn't started yet won't
+	shutdown bool
 }
 
 func Version() []int {
@@ -110,39 +268,122 @@ func VersionString() string {
 	return fmt.Sprintf("%d.%02d.%02d", v[0], v[1], v[2])
 }
 
+// Replace the stack's middleware
 func (this *Stack) Middleware(middleware ...Middleware) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	this.middleware = middleware
+	this.compiled.Store(App(nil))
+}
+
+// Add middleware to the inside of the stack, after any already added
+func (this *Stack) Use(middleware ...Middleware) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.middleware = append(this.middleware, middleware...)
+	this.compiled.Store(App(nil))
+}
+
+// Add a Router's middleware to the stack, as with Use, and make its named
+// routes available to Stack.URLFor. As with Router.Middleware, routes added
+// afterwards are ignored.
+func (this *Stack) UseRouter(router *Router) {
+	compiled, middleware := router.compile()
+	this.Use(middleware)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.routers = append(this.routers, mountedRouter{compiled, ""})
+}
+
+// Build the path for a named route from the stack's Routers. See
+// Router.URLFor.
+func (this *Stack) URLFor(name string, params map[string]string) (string, error) {
+	this.lock.Lock()
+	routers := this.routers
+	this.lock.Unlock()
+	return urlFor(routers, name, params)
 }
 
 func (this *Stack) Compile(app App) App {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.compile(app)
+}
+
+func (this *Stack) compile(app App) App {
 	this.app = app
-	return bundle(append(this.middleware, middlewareify(this.app))...)
+	// Copy the middleware, so later calls to Use can't change this app
+	stack := make([]Middleware, 0, len(this.middleware)+1)
+	stack = append(stack, this.middleware...)
+	compiled := bundle(append(stack, middlewareify(this.app))...)
+	this.compiled.Store(compiled)
+	return compiled
 }
 
 func (this *Stack) HandlerFunc(app App) http.HandlerFunc {
 	compiled_app := this.Compile(app)
 	return func(w http.ResponseWriter, r *http.Request) {
-		env := make(map[string]interface{})
-		env["mango.request"] = &Request{r}
-		env["mango.version"] = Version()
+		serve(compiled_app, w, r)
+	}
+}
 
-		status, headers, body := compiled_app(env)
+func (this *Stack) Handler(app App) http.Handler {
+	return this.HandlerFunc(app)
+}
 
-		for key, values := range headers {
-			for _, value := range values {
-				w.Header().Add(key, value)
+// Serve a request with the most recently compiled app. The stack is
+// recompiled if middleware has been added since. A stack which has never
+// been compiled responds with 404 to anything its middleware passes on.
+func (this *Stack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	compiled_app, _ := this.compiled.Load().(App)
+	if compiled_app == nil {
+		this.lock.Lock()
+		// Another request may have compiled it while we waited
+		compiled_app, _ = this.compiled.Load().(App)
+		if compiled_app == nil {
+			app := this.app
+			if app == nil {
+				app = notFound
 			}
+			compiled_app = this.compile(app)
 		}
-		w.WriteHeader(int(status))
-		w.Write([]byte(body))
+		this.lock.Unlock()
 	}
+
+	serve(compiled_app, w, r)
+}
+
+func notFound(env Env) (Status, Headers, Body) {
+	return 404, Headers{"Content-Type": []string{"text/plain"}}, Body("Not Found")
 }
 
-func (this *Stack) Run(app App) error {
-	if this.Address == "" {
-		this.Address = "0.0.0.0:8000"
+func serve(app App, w http.ResponseWriter, r *http.Request) {
+	env := make(Env)
+	env["mango.request"] = &Request{r}
+	env["mango.context"] = r.Context()
+	env["mango.version"] = Version()
+
+	respond(env, app, w)
+}
+
+// Call the app and write its response out to w
+func respond(env Env, app App, w http.ResponseWriter) {
+	status, headers, body := app(env)
+
+	for key, values := range headers {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(int(status))
+
+	if stream := env.Stream(); stream != nil {
+		// It's been sent now, so nothing else should send it
+		env.SetStream(nil)
+		if err := stream(newFlushWriter(w)); err != nil {
+			env.Logger().Println("Error streaming response:", err)
+		}
+		return
 	}
-	fmt.Println("Starting Mango Stack On:", this.Address)
-	http.HandleFunc("/", this.HandlerFunc(app))
-	return http.ListenAndServe(this.Address, nil)
+	w.Write([]byte(body))
 }
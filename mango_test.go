@@ -1,11 +1,14 @@
 package mango
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 )
 
 func helloWorld(env Env) (Status, Headers, Body) {
@@ -54,3 +57,201 @@ func BenchmarkHelloWorld(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+type contextTestKey struct{}
+
+func TestContextDefaultsToRequest(t *testing.T) {
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	ctx := context.WithValue(context.Background(), contextTestKey{}, "foo")
+	env := Env{"mango.request": &Request{request.WithContext(ctx)}}
+
+	if env.Context().Value(contextTestKey{}) != "foo" {
+		t.Error("Expected the context to come from the request")
+	}
+
+	if (Env{}).Context() != context.Background() {
+		t.Error("Expected an empty env to have the background context")
+	}
+}
+
+func TestContextMiddleware(t *testing.T) {
+	deadline := func(env Env, app App) (Status, Headers, Body) {
+		ctx, cancel := context.WithTimeout(env.Context(), time.Minute)
+		defer cancel()
+		return app(env.WithContext(context.WithValue(ctx, contextTestKey{}, "foo")))
+	}
+
+	contextTestServer := func(env Env) (Status, Headers, Body) {
+		if _, ok := env.Context().Deadline(); !ok {
+			return 500, Headers{}, Body("Expected a deadline")
+		}
+		if env.Context().Value(contextTestKey{}) != "foo" {
+			return 500, Headers{}, Body("Expected a context value")
+		}
+		if env.Request().Context() != env.Context() {
+			return 500, Headers{}, Body("Expected the request to carry the context")
+		}
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	stack := new(Stack)
+	stack.Middleware(deadline)
+	testServer := httptest.NewServer(stack.HandlerFunc(contextTestServer))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		t.Error("Expected status to equal 200, got:", response.StatusCode)
+	}
+}
+
+func TestContextCancelledOnDisconnect(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	contextTestServer := func(env Env) (Status, Headers, Body) {
+		select {
+		case <-env.Context().Done():
+			cancelled <- true
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+		}
+		return 200, Headers{}, Body("")
+	}
+
+	stack := new(Stack)
+	testServer := httptest.NewServer(stack.HandlerFunc(contextTestServer))
+	defer testServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request, _ := http.NewRequest("GET", testServer.URL, nil)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	http.DefaultClient.Do(request.WithContext(ctx))
+
+	if !<-cancelled {
+		t.Error("Expected the context to be cancelled when the client went away")
+	}
+}
+
+func appendingMiddleware(suffix string) Middleware {
+	return func(env Env, app App) (Status, Headers, Body) {
+		status, headers, body := app(env)
+		return status, headers, body + Body(suffix)
+	}
+}
+
+func TestUse(t *testing.T) {
+	stack := new(Stack)
+	stack.Use(appendingMiddleware(" A"))
+	stack.Use(appendingMiddleware(" B"), appendingMiddleware(" C"))
+	compiled := stack.Compile(helloWorld)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, _, body := compiled(Env{"mango.request": &Request{request}})
+
+	// Outermost middleware was added first, so it appends last
+	expected := "Hello World! C B A"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+
+	// Adding more middleware doesn't change an already compiled app
+	stack.Use(appendingMiddleware(" D"))
+	_, _, body = compiled(Env{"mango.request": &Request{request}})
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	stack := new(Stack)
+	stack.Use(appendingMiddleware(" A"))
+	stack.Compile(helloWorld)
+
+	// Mount the stack in its own mux, rather than the default one
+	mux := http.NewServeMux()
+	mux.Handle("/", stack)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	mux.ServeHTTP(recorder, request)
+
+	expected := "Hello World! A"
+	if recorder.Body.String() != expected {
+		t.Error("Expected body:", recorder.Body.String(), "to equal:", expected)
+	}
+
+	// Middleware added later is picked up on the next request
+	stack.Use(appendingMiddleware(" B"))
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+
+	expected = "Hello World! B A"
+	if recorder.Body.String() != expected {
+		t.Error("Expected body:", recorder.Body.String(), "to equal:", expected)
+	}
+}
+
+func TestServeHTTPConcurrently(t *testing.T) {
+	stack := new(Stack)
+	stack.Compile(helloWorld)
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+
+	// Requests are served while middleware is added, which go test -race
+	// checks is safe
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				stack.ServeHTTP(httptest.NewRecorder(), request)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		stack.Use(appendingMiddleware(""))
+	}
+	wait.Wait()
+
+	recorder := httptest.NewRecorder()
+	stack.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "Hello World!" {
+		t.Error("Expected body:", recorder.Body.String(), "to equal: \"Hello World!\"")
+	}
+}
+
+func TestServeHTTPWithoutApp(t *testing.T) {
+	stack := new(Stack)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	stack.ServeHTTP(recorder, request)
+
+	if recorder.Code != 404 {
+		t.Error("Expected status to equal 404, got:", recorder.Code)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	stack := new(Stack)
+	testServer := httptest.NewServer(stack.Handler(helloWorld))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if string(body) != "Hello World!" {
+		t.Error("Expected body:", string(body), "to equal: \"Hello World!\"")
+	}
+}
@@ -0,0 +1,44 @@
+package mango
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Mount an app, typically a compiled sub-stack, at a path prefix, like
+// Rack's URLMap. Requests for the prefix, or paths below it, are passed to
+// the app with the prefix stripped from the path, and the prefix added to
+// Env.BasePath(). Anything else is passed upstream.
+//
+//	stack.Use(mango.Mount("/admin", adminStack.Compile(adminApp)))
+func Mount(prefix string, app App) Middleware {
+	prefix = strings.TrimRight(prefix, "/")
+
+	return func(env Env, upstream App) (Status, Headers, Body) {
+		request := env.Request()
+		path := request.URL.Path
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return upstream(env)
+		}
+
+		mounted := new(url.URL)
+		*mounted = *request.URL
+		mounted.Path = strings.TrimPrefix(path, prefix)
+		mounted.RawPath = ""
+		if mounted.Path == "" {
+			mounted.Path = "/"
+		}
+		inner := request.WithContext(request.Context())
+		inner.URL = mounted
+
+		// Put things back for the middleware outside the mount
+		base := env["mango.base_path"]
+		defer func() {
+			env["mango.request"], env["mango.base_path"] = request, base
+		}()
+
+		env["mango.request"] = &Request{inner}
+		env["mango.base_path"] = env.BasePath() + prefix
+		return app(env)
+	}
+}
@@ -0,0 +1,82 @@
+package mango
+
+import (
+	"net/http"
+	"testing"
+)
+
+func mountTestServer(env Env) (Status, Headers, Body) {
+	return 200, Headers{}, Body(env.BasePath() + " " + env.Request().URL.Path)
+}
+
+func TestMount(t *testing.T) {
+	// Check the outer request is back in place once the mount returns
+	outerPath := func(env Env, app App) (Status, Headers, Body) {
+		status, headers, body := app(env)
+		headers.Set("X-Outer-Path", env.Request().URL.Path)
+		return status, headers, body
+	}
+
+	mountStack := new(Stack)
+	mountStack.Use(outerPath, Mount("/admin/", mountTestServer))
+	mountApp := mountStack.Compile(routingTestServer)
+
+	for _, test := range []struct {
+		url, expected string
+	}{
+		{"http://localhost:3000/admin/users?page=2", "/admin /users"},
+		{"http://localhost:3000/admin", "/admin /"},
+		{"http://localhost:3000/admin/", "/admin /"},
+		{"http://localhost:3000/administrator", "Hello World!"},
+		{"http://localhost:3000/", "Hello World!"},
+	} {
+		request, _ := http.NewRequest("GET", test.url, nil)
+		_, headers, body := mountApp(Env{"mango.request": &Request{request}})
+
+		if string(body) != test.expected {
+			t.Error("Expected body of", test.url, "to equal:", test.expected, "got:", string(body))
+		}
+
+		if headers.Get("X-Outer-Path") != request.URL.Path {
+			t.Error("Expected X-Outer-Path to equal:", request.URL.Path, "got:", headers.Get("X-Outer-Path"))
+		}
+	}
+}
+
+func TestNestedMount(t *testing.T) {
+	router := new(Router)
+	router.Get("/users/:id", func(env Env) (Status, Headers, Body) {
+		url, err := env.URLFor("user_show", map[string]string{"id": env.Param("id")})
+		if err != nil {
+			return 500, Headers{}, Body(err.Error())
+		}
+		return 200, Headers{}, Body(url)
+	}).Name = "user_show"
+
+	innerStack := new(Stack)
+	innerStack.Use(router.Middleware())
+
+	middleStack := new(Stack)
+	middleStack.Use(Mount("/v1", innerStack.Compile(mountTestServer)))
+
+	outerStack := new(Stack)
+	outerStack.Use(Mount("/api", middleStack.Compile(mountTestServer)))
+	outerApp := outerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/api/v1/other", nil)
+	_, _, body := outerApp(Env{"mango.request": &Request{request}})
+
+	expected := "/api/v1 /other"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+
+	// URLs built inside the mount include its prefix
+	request, _ = http.NewRequest("GET", "http://localhost:3000/api/v1/users/123", nil)
+	_, _, body = outerApp(Env{"mango.request": &Request{request}})
+
+	expected = "/api/v1/users/123"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
@@ -1,8 +1,13 @@
 package mango
 
 import (
+	"fmt"
+	"log"
+	"net/url"
 	"regexp"
+	"regexp/syntax"
 	"sort"
+	"strings"
 )
 
 // Methods required by sort.Interface.
@@ -15,6 +20,10 @@ func (this matcherArray) Len() int {
 	return len(this)
 }
 func (this matcherArray) Less(i, j int) bool {
+	if specificity(this[i]) == specificity(this[j]) {
+		// Break ties by the pattern, so the order is the same every run
+		return this[i].String() < this[j].String()
+	}
 	// The sign is reversed below so we sort the matchers in descending order
 	return specificity(this[i]) > specificity(this[j])
 }
@@ -22,23 +31,111 @@ func (this matcherArray) Swap(i, j int) {
 	this[i], this[j] = this[j], this[i]
 }
 
+var paramMatcher = regexp.MustCompile(`(^|/):([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+// Expand ":name" path segments in a route pattern into named groups, so
+// "/users/:id" matches like "/users/(?P<id>[^/]+)".
+func expandParams(pattern string) string {
+	return paramMatcher.ReplaceAllString(pattern, "$1(?P<$2>[^/]+)")
+}
+
+// Build a path which the regex matches, for checking whether routes
+// overlap. It's the shortest match, taking the first choice everywhere, so
+// checking it against other routes catches common overlaps but not all.
+func samplePath(matcher *regexp.Regexp) string {
+	re, err := syntax.Parse(matcher.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	var sample strings.Builder
+	writeSample(&sample, re)
+	return sample.String()
+}
+
+func writeSample(sample *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		sample.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		if len(re.Rune) > 0 {
+			sample.WriteRune(re.Rune[0])
+		}
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		sample.WriteRune('x')
+	case syntax.OpCapture:
+		writeSample(sample, re.Sub[0])
+	case syntax.OpPlus:
+		writeSample(sample, re.Sub[0])
+	case syntax.OpRepeat:
+		for i := 0; i < re.Min; i++ {
+			writeSample(sample, re.Sub[0])
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writeSample(sample, sub)
+		}
+	case syntax.OpAlternate:
+		writeSample(sample, re.Sub[0])
+	}
+}
+
+// Warn about routes of equal length which can match the same path, as
+// which one wins is down to the spelling of their patterns.
+func warnRoutingOverlaps(matchers matcherArray) {
+	for i := range matchers {
+		for j := i + 1; j < len(matchers) && specificity(matchers[i]) == specificity(matchers[j]); j++ {
+			if matchers[i].MatchString(samplePath(matchers[j])) || matchers[j].MatchString(samplePath(matchers[i])) {
+				log.Printf("mango: routes %q and %q are ambiguous; %q takes precedence", matchers[i], matchers[j], matchers[i])
+			}
+		}
+	}
+}
+
+// Inject the matches for a route into the env, along with any named params
+func setMatches(env Env, matcher *regexp.Regexp, matches []string) {
+	env["Routing.matches"] = matches
+
+	params := make(map[string]string)
+	for i, name := range matcher.SubexpNames() {
+		if name != "" {
+			params[name] = matches[i]
+		}
+	}
+	addParams(env, params)
+}
+
+// Add to the params in the env, keeping those set by earlier middleware
+func addParams(env Env, params map[string]string) {
+	merged := make(map[string]string)
+	for key, value := range env.Params() {
+		merged[key] = value
+	}
+	for key, value := range params {
+		merged[key] = value
+	}
+	env["mango.params"] = merged
+}
+
 func Routing(routes map[string]App) Middleware {
 	matchers := matcherArray{}
 	handlers := []App{}
+	compiled := make(map[*regexp.Regexp]App)
 
 	// Compile the matchers
-	for matcher, _ := range routes {
-		// compile the matchers
-		matchers = append([]*regexp.Regexp(matchers), regexp.MustCompile(matcher))
+	for pattern, handler := range routes {
+		matcher := regexp.MustCompile(expandParams(pattern))
+		matchers = append(matchers, matcher)
+		compiled[matcher] = handler
 	}
 
 	// sort 'em by descending length
 	sort.Sort(matchers)
+	warnRoutingOverlaps(matchers)
 
 	// Attach the handlers to each matcher
 	for _, matcher := range matchers {
 		// Attach them to their handlers
-		handlers = append(handlers, routes[matcher.String()])
+		handlers = append(handlers, compiled[matcher])
 	}
 
 	return func(env Env, app App) (Status, Headers, Body) {
@@ -46,7 +143,7 @@ func Routing(routes map[string]App) Middleware {
 			matches := matcher.FindStringSubmatch(env.Request().URL.Path)
 			if len(matches) != 0 {
 				// Matched a route; inject matches and return handler
-				env["Routing.matches"] = matches
+				setMatches(env, matcher, matches)
 				return handlers[i](env)
 			}
 		}
@@ -55,3 +152,346 @@ func Routing(routes map[string]App) Middleware {
 		return app(env)
 	}
 }
+
+// A Router dispatches requests to apps by HTTP method and path. Unlike
+// Routing, patterns must match the whole path. Routes are tried in order
+// of descending Priority, then in the order they were added. A warning is
+// logged if a route is added after one of the same priority which matches
+// some of the same paths.
+//
+// If a route's pattern matches the path but none match the method, the
+// Router responds with 405 Method Not Allowed and an Allow header.
+// OPTIONS requests are answered automatically with the Allow header, and
+// HEAD requests are served by the GET route, without the body.
+//
+//	router := new(mango.Router)
+//	router.Get("/users", listUsers)
+//	router.Post("/users", createUser)
+//	router.Get("/users/:id", showUser)
+//	router.Get("/users/new", newUser).Priority = 1
+//	router.Get("/users/:id/edit", editUser).Name = "user_edit"
+//	stack.Use(router.Middleware())
+type Router struct {
+	routes []*Route
+	tree   bool
+
+	// Set for groups made with Group
+	parent     *Router
+	prefix     string
+	middleware []Middleware
+}
+
+type Route struct {
+	Method  string
+	Pattern string
+	// Routes with a Name can be built with URLFor
+	Name string
+	// Routes with a higher Priority are tried first
+	Priority int
+	app      App
+	expanded string
+	matcher  *regexp.Regexp
+}
+
+// Methods required by sort.Interface.
+type routeArray []*Route
+
+func (this routeArray) Len() int {
+	return len(this)
+}
+func (this routeArray) Less(i, j int) bool {
+	return this[i].Priority > this[j].Priority
+}
+func (this routeArray) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+// Warn about routes which are partly hidden by an earlier route of the
+// same priority, as only registration order decides between them.
+func warnRouterOverlaps(routes routeArray) {
+	for j, later := range routes {
+		sample := samplePath(later.matcher)
+		for _, earlier := range routes[:j] {
+			if earlier.Method == later.Method && earlier.Priority == later.Priority && earlier.matcher.MatchString(sample) {
+				log.Printf("mango: route %s %q is ambiguous with %q, which was added first and takes precedence", later.Method, later.Pattern, earlier.Pattern)
+			}
+		}
+	}
+}
+
+// Make a group of routes whose patterns all start with prefix, and whose
+// apps are wrapped in the given middleware (inside that of any enclosing
+// group). Routes added to the group are added to this Router. Unlike
+// Mount, the prefix isn't stripped from the path.
+//
+//	admin := router.Group("/admin", mango.BasicAuth(auth, nil))
+//	admin.Get("/users", listUsers) // GET /admin/users
+func (this *Router) Group(prefix string, middleware ...Middleware) *Router {
+	return &Router{
+		tree:       this.tree,
+		parent:     this,
+		prefix:     strings.TrimRight(prefix, "/"),
+		middleware: middleware,
+	}
+}
+
+// The Router routes are actually added to, for groups
+func (this *Router) root() *Router {
+	if this.parent != nil {
+		return this.parent.root()
+	}
+	return this
+}
+
+// Add a route for method and path pattern
+func (this *Router) Handle(method, pattern string, app App) *Route {
+	if this.parent != nil {
+		if len(this.middleware) > 0 {
+			app = bundle(append(append([]Middleware{}, this.middleware...), middlewareify(app))...)
+		}
+		return this.parent.Handle(method, this.prefix+pattern, app)
+	}
+
+	expanded := expandParams(pattern)
+	if this.tree {
+		expanded = expandTreePattern(pattern)
+	}
+
+	route := &Route{
+		Method:   strings.ToUpper(method),
+		Pattern:  pattern,
+		app:      app,
+		expanded: expanded,
+		matcher:  regexp.MustCompile("^(?:" + expanded + ")$"),
+	}
+	this.routes = append(this.routes, route)
+	return route
+}
+
+func (this *Router) Get(pattern string, app App) *Route {
+	return this.Handle("GET", pattern, app)
+}
+
+func (this *Router) Post(pattern string, app App) *Route {
+	return this.Handle("POST", pattern, app)
+}
+
+func (this *Router) Put(pattern string, app App) *Route {
+	return this.Handle("PUT", pattern, app)
+}
+
+func (this *Router) Delete(pattern string, app App) *Route {
+	return this.Handle("DELETE", pattern, app)
+}
+
+func (this *Router) Patch(pattern string, app App) *Route {
+	return this.Handle("PATCH", pattern, app)
+}
+
+// Compile the router into Middleware. Routes added afterwards are ignored.
+// For a group, this compiles the Router it belongs to.
+func (this *Router) Middleware() Middleware {
+	_, middleware := this.compile()
+	return middleware
+}
+
+// Snapshot the routes, returning them along with the Middleware which
+// serves them, so URLs are only built for routes which are served.
+func (this *Router) compile() (*compiledRouter, Middleware) {
+	if this.parent != nil {
+		return this.root().compile()
+	}
+
+	routes := make(routeArray, len(this.routes))
+	copy(routes, this.routes)
+	sort.Stable(routes)
+	compiled := &compiledRouter{routes}
+
+	var lookup routeLookup
+	if this.tree {
+		lookup = buildTree(routes).lookup
+	} else {
+		warnRouterOverlaps(routes)
+		lookup = routes.lookup
+	}
+
+	return compiled, func(env Env, app App) (Status, Headers, Body) {
+		request := env.Request()
+		routers, _ := env["mango.routers"].([]mountedRouter)
+		if len(routers) == 0 || routers[0].router != compiled || routers[0].base != env.BasePath() {
+			env["mango.routers"] = append([]mountedRouter{{compiled, env.BasePath()}}, routers...)
+		}
+
+		found, get, allowed := lookup(request.URL.Path, request.Method)
+		if found != nil {
+			setMatches(env, found.route.matcher, found.matches)
+			return found.route.app(env)
+		}
+
+		if len(allowed) == 0 {
+			// didn't match any routes. pass upstream.
+			return app(env)
+		}
+
+		switch {
+		case request.Method == "HEAD" && get != nil:
+			setMatches(env, get.route.matcher, get.matches)
+			status, headers, _ := get.route.app(env)
+			env.DiscardStream()
+			return status, headers, Body("")
+		case request.Method == "OPTIONS":
+			return 200, Headers{"Allow": []string{allowHeader(allowed)}}, Body("")
+		}
+
+		return 405, Headers{"Allow": []string{allowHeader(allowed)}, "Content-Type": []string{"text/plain"}}, Body("Method Not Allowed")
+	}
+}
+
+type routeMatch struct {
+	route   *Route
+	matches []string
+}
+
+// Find the route for a path and method. When there isn't one, also find
+// the GET route for the path, if any, and the methods routed for the path.
+type routeLookup func(path, method string) (found, get *routeMatch, allowed []string)
+
+func (this routeArray) lookup(path, method string) (found, get *routeMatch, allowed []string) {
+	for _, route := range this {
+		matches := route.matcher.FindStringSubmatch(path)
+		if len(matches) == 0 {
+			continue
+		}
+
+		if route.Method == method {
+			return &routeMatch{route, matches}, nil, nil
+		}
+
+		if route.Method == "GET" && get == nil {
+			get = &routeMatch{route, matches}
+		}
+		allowed = append(allowed, route.Method)
+	}
+	return nil, get, allowed
+}
+
+// Build the Allow header from the methods routed for a path, along with
+// the methods the Router answers automatically.
+func allowHeader(methods []string) string {
+	unique := map[string]bool{"OPTIONS": true}
+	for _, method := range methods {
+		unique[method] = true
+		if method == "GET" {
+			unique["HEAD"] = true
+		}
+	}
+
+	allowed := make([]string, 0, len(unique))
+	for method := range unique {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// Build the path for the route with the given name, filling in its
+// parameters. Any params which aren't in the route's pattern are added as
+// a query string. Only patterns made of literal text and named params can
+// be built. This uses every route added so far, even those added after the
+// Router was compiled into Middleware.
+func (this *Router) URLFor(name string, params map[string]string) (string, error) {
+	return urlFor([]mountedRouter{{&compiledRouter{this.root().routes}, ""}}, name, params)
+}
+
+// The routes a Router's Middleware serves
+type compiledRouter struct {
+	routes routeArray
+}
+
+// A Router a request has passed through, and the base path it was
+// mounted at
+type mountedRouter struct {
+	router *compiledRouter
+	base   string
+}
+
+// Build the path for the named route from the first Router which has it
+func urlFor(routers []mountedRouter, name string, params map[string]string) (string, error) {
+	for _, mounted := range routers {
+		for _, route := range mounted.router.routes {
+			if route.Name == name {
+				url, err := route.URL(params)
+				if err != nil {
+					return "", err
+				}
+				return mounted.base + url, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("mango: no route named %q", name)
+}
+
+// Build the path for this route, as for Router.URLFor.
+func (this *Route) URL(params map[string]string) (string, error) {
+	re, err := syntax.Parse(this.expanded, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var path, escaped strings.Builder
+	used := make(map[string]bool)
+	if err := this.buildURL(re, params, used, &path, &escaped); err != nil {
+		return "", err
+	}
+
+	if !this.matcher.MatchString(path.String()) {
+		return "", fmt.Errorf("mango: %q doesn't match route %q", path.String(), this.Pattern)
+	}
+
+	query := url.Values{}
+	for key, value := range params {
+		if !used[key] {
+			query.Set(key, value)
+		}
+	}
+	if len(query) > 0 {
+		escaped.WriteString("?" + query.Encode())
+	}
+	return escaped.String(), nil
+}
+
+func (this *Route) buildURL(re *syntax.Regexp, params map[string]string, used map[string]bool, path, escaped *strings.Builder) error {
+	switch re.Op {
+	case syntax.OpLiteral:
+		path.WriteString(string(re.Rune))
+		escaped.WriteString(string(re.Rune))
+	case syntax.OpCapture:
+		if re.Name == "" {
+			return fmt.Errorf("mango: can't build a URL for route %q with unnamed group %q", this.Pattern, re)
+		}
+		value, ok := params[re.Name]
+		if !ok {
+			return fmt.Errorf("mango: missing param %q for route %q", re.Name, this.Pattern)
+		}
+		if !regexp.MustCompile("^(?:" + re.Sub[0].String() + ")$").MatchString(value) {
+			return fmt.Errorf("mango: param %q of %q doesn't match %q in route %q", re.Name, value, re.Sub[0], this.Pattern)
+		}
+		used[re.Name] = true
+		path.WriteString(value)
+		segments := strings.Split(value, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		escaped.WriteString(strings.Join(segments, "/"))
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := this.buildURL(sub, params, used, path, escaped); err != nil {
+				return err
+			}
+		}
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+	default:
+		return fmt.Errorf("mango: can't build a URL for route %q from %q", this.Pattern, re)
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package mango
 
 import (
+	"bytes"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -132,3 +136,617 @@ func BenchmarkRouting(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func routerTestStack() App {
+	router := new(Router)
+	router.Get("/users", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{"Content-Length": []string{"10"}}, Body("List users")
+	})
+	router.Post("/users", func(env Env) (Status, Headers, Body) {
+		return 201, Headers{}, Body("Create user")
+	})
+	router.Delete("/users/([0-9]+)", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Delete user " + env["Routing.matches"].([]string)[1])
+	})
+
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	return routerStack.Compile(routingTestServer)
+}
+
+func TestRouterMethods(t *testing.T) {
+	routerApp := routerTestStack()
+
+	for _, test := range []struct {
+		method, url, expected string
+		status                Status
+	}{
+		{"GET", "http://localhost:3000/users", "List users", 200},
+		{"POST", "http://localhost:3000/users", "Create user", 201},
+		{"DELETE", "http://localhost:3000/users/123", "Delete user 123", 200},
+		// Patterns must match the whole path
+		{"GET", "http://localhost:3000/users/123/posts", "Hello World!", 200},
+		{"GET", "http://localhost:3000/other", "Hello World!", 200},
+	} {
+		request, _ := http.NewRequest(test.method, test.url, nil)
+		status, _, body := routerApp(Env{"mango.request": &Request{request}})
+
+		if status != test.status {
+			t.Error("Expected status of", test.method, test.url, "to equal", test.status, "got:", status)
+		}
+
+		if string(body) != test.expected {
+			t.Error("Expected body of", test.method, test.url, "to equal:", test.expected, "got:", string(body))
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	routerApp := routerTestStack()
+
+	request, _ := http.NewRequest("PUT", "http://localhost:3000/users", nil)
+	status, headers, _ := routerApp(Env{"mango.request": &Request{request}})
+
+	if status != 405 {
+		t.Error("Expected status to equal 405, got:", status)
+	}
+
+	expected := "GET, HEAD, OPTIONS, POST"
+	if headers.Get("Allow") != expected {
+		t.Error("Expected Allow to equal:", expected, "got:", headers.Get("Allow"))
+	}
+
+	request, _ = http.NewRequest("GET", "http://localhost:3000/users/123", nil)
+	status, headers, _ = routerApp(Env{"mango.request": &Request{request}})
+
+	if status != 405 {
+		t.Error("Expected status to equal 405, got:", status)
+	}
+
+	expected = "DELETE, OPTIONS"
+	if headers.Get("Allow") != expected {
+		t.Error("Expected Allow to equal:", expected, "got:", headers.Get("Allow"))
+	}
+}
+
+func TestRouterOptions(t *testing.T) {
+	routerApp := routerTestStack()
+
+	request, _ := http.NewRequest("OPTIONS", "http://localhost:3000/users", nil)
+	status, headers, body := routerApp(Env{"mango.request": &Request{request}})
+
+	if status != 200 {
+		t.Error("Expected status to equal 200, got:", status)
+	}
+
+	expected := "GET, HEAD, OPTIONS, POST"
+	if headers.Get("Allow") != expected {
+		t.Error("Expected Allow to equal:", expected, "got:", headers.Get("Allow"))
+	}
+
+	if string(body) != "" {
+		t.Error("Expected an empty body, got:", string(body))
+	}
+}
+
+func TestRouterHead(t *testing.T) {
+	routerApp := routerTestStack()
+
+	request, _ := http.NewRequest("HEAD", "http://localhost:3000/users", nil)
+	status, headers, body := routerApp(Env{"mango.request": &Request{request}})
+
+	if status != 200 {
+		t.Error("Expected status to equal 200, got:", status)
+	}
+
+	if headers.Get("Content-Length") != "10" {
+		t.Error("Expected Content-Length to equal \"10\", got:", headers.Get("Content-Length"))
+	}
+
+	if string(body) != "" {
+		t.Error("Expected an empty body, got:", string(body))
+	}
+}
+
+func TestRouterHeadDiscardsStream(t *testing.T) {
+	reader := &closeRecorder{Reader: strings.NewReader("Hello World!")}
+	router := new(Router)
+	router.Get("/file", func(env Env) (Status, Headers, Body) {
+		return Streaming(env, 200, Headers{}, ReaderStream(reader))
+	})
+
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	routerApp := routerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("HEAD", "http://localhost:3000/file", nil)
+	env := Env{"mango.request": &Request{request}}
+	status, _, _ := routerApp(env)
+
+	if status != 200 {
+		t.Error("Expected status to equal 200, got:", status)
+	}
+	if env.Stream() != nil {
+		t.Error("Expected the stream to be removed")
+	}
+	if !reader.closed {
+		t.Error("Expected the stream's reader to be closed")
+	}
+}
+
+func TestRoutingParams(t *testing.T) {
+	postTestServer := func(env Env) (Status, Headers, Body) {
+		id, err := env.ParamInt("id")
+		if err != nil {
+			return 500, Headers{}, Body(err.Error())
+		}
+		return 200, Headers{}, Body(fmt.Sprintf("User %d, post %s", id, env.Param("slug")))
+	}
+
+	commentTestServer := func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Comment " + env.Param("comment"))
+	}
+
+	// Compile the stack
+	routingStack := new(Stack)
+	routes := make(map[string]App)
+	routes["^/users/:id/posts/:slug$"] = postTestServer
+	routes["^/comments/(?P<comment>[0-9]+)$"] = commentTestServer
+	routingStack.Middleware(Routing(routes))
+	routingApp := routingStack.Compile(routingTestServer)
+
+	for _, test := range []struct {
+		url, expected string
+		status        Status
+	}{
+		{"http://localhost:3000/users/123/posts/hello-world", "User 123, post hello-world", 200},
+		{"http://localhost:3000/users/abc/posts/hello-world", "strconv.Atoi: parsing \"abc\": invalid syntax", 500},
+		{"http://localhost:3000/comments/456", "Comment 456", 200},
+		{"http://localhost:3000/comments/abc", "Hello World!", 200},
+	} {
+		request, _ := http.NewRequest("GET", test.url, nil)
+		status, _, body := routingApp(Env{"mango.request": &Request{request}})
+
+		if status != test.status {
+			t.Error("Expected status of", test.url, "to equal", test.status, "got:", status)
+		}
+
+		if string(body) != test.expected {
+			t.Error("Expected body of", test.url, "to equal:", test.expected, "got:", string(body))
+		}
+	}
+}
+
+func TestRouterParams(t *testing.T) {
+	router := new(Router)
+	router.Get("/users/:id", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("User " + env.Param("id"))
+	})
+
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	routerApp := routerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/users/123", nil)
+	env := Env{"mango.request": &Request{request}}
+	_, _, body := routerApp(env)
+
+	expected := "User 123"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+
+	if matches := env["Routing.matches"].([]string); matches[1] != "123" {
+		t.Error("Expected Routing.matches to still be set, got:", matches)
+	}
+}
+
+func TestParamIntMissing(t *testing.T) {
+	if _, err := (Env{}).ParamInt("id"); err == nil {
+		t.Error("Expected an error for a missing param")
+	}
+}
+
+func captureLog(f func()) string {
+	buffer := new(bytes.Buffer)
+	previous := log.Writer()
+	log.SetOutput(buffer)
+	defer log.SetOutput(previous)
+	f()
+	return buffer.String()
+}
+
+func TestRoutingDeterministicOrder(t *testing.T) {
+	routes := make(map[string]App)
+	routes["/ab.*"] = routingATestServer
+	routes["/a.*b"] = routingBTestServer
+
+	// Both patterns are the same length, and both match "/abb"
+	warnings := captureLog(func() {
+		Routing(routes)
+	})
+
+	if !strings.Contains(warnings, "ambiguous") {
+		t.Error("Expected a warning about ambiguous routes, got:", warnings)
+	}
+
+	// Map order changes between runs, so build it a few times. Each build
+	// warns again, so keep the warnings out of the test output.
+	captureLog(func() {
+		for i := 0; i < 20; i++ {
+			routingStack := new(Stack)
+			routingStack.Middleware(Routing(routes))
+			routingApp := routingStack.Compile(routingTestServer)
+
+			request, _ := http.NewRequest("GET", "http://localhost:3000/abb", nil)
+			_, _, body := routingApp(Env{"mango.request": &Request{request}})
+
+			expected := "Server B"
+			if string(body) != expected {
+				t.Fatal("Expected body:", string(body), "to equal:", expected)
+			}
+		}
+	})
+}
+
+func TestRouterPriority(t *testing.T) {
+	router := new(Router)
+	router.Get("/users/:id", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Show user")
+	})
+	router.Get("/users/new", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("New user")
+	}).Priority = 1
+
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	routerApp := routerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/users/new", nil)
+	_, _, body := routerApp(Env{"mango.request": &Request{request}})
+
+	expected := "New user"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestRouterOverlapWarning(t *testing.T) {
+	router := new(Router)
+	router.Get("/users/:id", routingATestServer)
+	router.Get("/users/new", routingBTestServer)
+	router.Post("/users/new", routingBTestServer)
+
+	warnings := captureLog(func() {
+		router.Middleware()
+	})
+
+	expected := "mango: route GET \"/users/new\" is ambiguous with \"/users/:id\""
+	if !strings.Contains(warnings, expected) {
+		t.Error("Expected warning:", warnings, "to contain:", expected)
+	}
+
+	if strings.Contains(warnings, "POST") {
+		t.Error("Expected no warning for routes with different methods, got:", warnings)
+	}
+
+	// The more specific route first is unambiguous
+	router = new(Router)
+	router.Get("/users/new", routingBTestServer)
+	router.Get("/users/:id", routingATestServer)
+
+	warnings = captureLog(func() {
+		router.Middleware()
+	})
+
+	if warnings != "" {
+		t.Error("Expected no warnings, got:", warnings)
+	}
+}
+
+func TestRouterURLFor(t *testing.T) {
+	router := new(Router)
+	router.Get("/users/:id/posts/:slug", routingATestServer).Name = "post_show"
+	router.Get("/comments/(?P<comment>[0-9]+)", routingATestServer).Name = "comment_show"
+	router.Get("/files/(.*)", routingATestServer).Name = "file_show"
+
+	for _, test := range []struct {
+		name     string
+		params   map[string]string
+		expected string
+		err      bool
+	}{
+		{"post_show", map[string]string{"id": "123", "slug": "hello world"}, "/users/123/posts/hello%20world", false},
+		{"post_show", map[string]string{"id": "123", "slug": "hello", "page": "2"}, "/users/123/posts/hello?page=2", false},
+		{"post_show", map[string]string{"id": "123"}, "", true},
+		{"post_show", map[string]string{"id": "1/2", "slug": "hello"}, "", true},
+		{"comment_show", map[string]string{"comment": "456"}, "/comments/456", false},
+		{"comment_show", map[string]string{"comment": "abc"}, "", true},
+		{"file_show", map[string]string{}, "", true},
+		{"missing", map[string]string{}, "", true},
+	} {
+		url, err := router.URLFor(test.name, test.params)
+
+		if test.err && err == nil {
+			t.Error("Expected an error building", test.name, test.params, "got:", url)
+		}
+
+		if !test.err && err != nil {
+			t.Error("Expected no error building", test.name, test.params, "got:", err)
+		}
+
+		if url != test.expected {
+			t.Error("Expected URL for", test.name, test.params, "to equal:", test.expected, "got:", url)
+		}
+	}
+}
+
+func TestEnvURLFor(t *testing.T) {
+	router := new(Router)
+	router.Get("/users/:id", func(env Env) (Status, Headers, Body) {
+		url, err := env.URLFor("user_edit", map[string]string{"id": env.Param("id")})
+		if err != nil {
+			return 500, Headers{}, Body(err.Error())
+		}
+		return Redirect(302, url)
+	}).Name = "user_show"
+	router.Get("/users/:id/edit", routingATestServer).Name = "user_edit"
+
+	routerStack := new(Stack)
+	routerStack.UseRouter(router)
+	routerApp := routerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/users/123", nil)
+	status, headers, _ := routerApp(Env{"mango.request": &Request{request}})
+
+	if status != 302 {
+		t.Error("Expected status to equal 302, got:", status)
+	}
+
+	expected := "/users/123/edit"
+	if headers.Get("Location") != expected {
+		t.Error("Expected Location:", headers.Get("Location"), "to equal:", expected)
+	}
+
+	url, err := routerStack.URLFor("user_show", map[string]string{"id": "456"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected = "/users/456"
+	if url != expected {
+		t.Error("Expected URL:", url, "to equal:", expected)
+	}
+
+	// Routes added after compiling aren't served, so their URLs aren't built
+	router.Get("/users/:id/delete", routingBTestServer).Name = "user_delete"
+	if url, err := routerStack.URLFor("user_delete", map[string]string{"id": "456"}); err == nil {
+		t.Error("Expected an error for a route which isn't served, got:", url)
+	}
+
+	request, _ = http.NewRequest("GET", "http://localhost:3000/users/123", nil)
+	env := Env{"mango.request": &Request{request}}
+	routerApp(env)
+	if url, err := env.URLFor("user_delete", map[string]string{"id": "456"}); err == nil {
+		t.Error("Expected an error for a route which isn't served, got:", url)
+	}
+
+	// The Router itself knows about every route
+	if _, err := router.URLFor("user_delete", map[string]string{"id": "456"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func treeRouterTestStack() App {
+	router := NewTreeRouter()
+	router.Get("/users/:id", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Show user " + env.Param("id"))
+	})
+	router.Get("/users/new", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("New user")
+	})
+	router.Post("/users", func(env Env) (Status, Headers, Body) {
+		return 201, Headers{}, Body("Create user")
+	})
+	router.Get("/users/:id/posts/:slug", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Post " + env.Param("slug") + " by " + env.Param("id"))
+	})
+	router.Get("/files/*path", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("File " + env.Param("path"))
+	})
+	router.Get("/index.html", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Index")
+	}).Name = "index"
+
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	return routerStack.Compile(routingTestServer)
+}
+
+func TestTreeRouter(t *testing.T) {
+	routerApp := treeRouterTestStack()
+
+	for _, test := range []struct {
+		method, url, expected string
+		status                Status
+	}{
+		{"GET", "http://localhost:3000/users/123", "Show user 123", 200},
+		{"GET", "http://localhost:3000/users/new", "New user", 200},
+		{"POST", "http://localhost:3000/users", "Create user", 201},
+		{"GET", "http://localhost:3000/users/123/posts/hello", "Post hello by 123", 200},
+		{"GET", "http://localhost:3000/files/css/site.css", "File css/site.css", 200},
+		{"GET", "http://localhost:3000/index.html", "Index", 200},
+		// Literal segments aren't regexes
+		{"GET", "http://localhost:3000/indexXhtml", "Hello World!", 200},
+		{"GET", "http://localhost:3000/users/123/posts", "Hello World!", 200},
+		{"GET", "http://localhost:3000/users/", "Hello World!", 200},
+		{"GET", "http://localhost:3000/users", "Method Not Allowed", 405},
+	} {
+		request, _ := http.NewRequest(test.method, test.url, nil)
+		status, _, body := routerApp(Env{"mango.request": &Request{request}})
+
+		if status != test.status {
+			t.Error("Expected status of", test.method, test.url, "to equal", test.status, "got:", status)
+		}
+
+		if string(body) != test.expected {
+			t.Error("Expected body of", test.method, test.url, "to equal:", test.expected, "got:", string(body))
+		}
+	}
+}
+
+func TestTreeRouterMethodFallback(t *testing.T) {
+	// Both routers send POST /users/new to the :id route, as the static
+	// route doesn't handle POST
+	for name, router := range map[string]*Router{"regex": new(Router), "tree": NewTreeRouter()} {
+		router.Get("/users/new", func(env Env) (Status, Headers, Body) {
+			return 200, Headers{}, Body("New user")
+		})
+		router.Post("/users/:id", func(env Env) (Status, Headers, Body) {
+			return 200, Headers{}, Body("Update user " + env.Param("id"))
+		})
+
+		routerStack := new(Stack)
+		routerStack.Use(router.Middleware())
+		routerApp := routerStack.Compile(routingTestServer)
+
+		for _, test := range []struct {
+			method, path, expected string
+			status                 Status
+		}{
+			{"GET", "/users/new", "New user", 200},
+			{"POST", "/users/new", "Update user new", 200},
+			{"PUT", "/users/new", "Method Not Allowed", 405},
+		} {
+			request, _ := http.NewRequest(test.method, "http://localhost:3000"+test.path, nil)
+			status, headers, body := routerApp(Env{"mango.request": &Request{request}})
+
+			if status != test.status || string(body) != test.expected {
+				t.Error(name, "router: expected", test.method, test.path, "to give:", test.status, test.expected, "got:", status, string(body))
+			}
+			if status == 405 && headers.Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+				t.Error(name, "router: expected all the matching routes' methods to be allowed, got:", headers.Get("Allow"))
+			}
+		}
+	}
+
+	// Wildcards are tried last
+	router := NewTreeRouter()
+	router.Get("/files/:name", routingATestServer)
+	router.Delete("/files/*path", routingBTestServer)
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	routerApp := routerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("DELETE", "http://localhost:3000/files/a.txt", nil)
+	_, _, body := routerApp(Env{"mango.request": &Request{request}})
+	if string(body) != "Server B" {
+		t.Error("Expected the wildcard route to handle DELETE, got:", string(body))
+	}
+}
+
+func TestTreeRouterURLFor(t *testing.T) {
+	router := NewTreeRouter()
+	router.Get("/files/*path", routingATestServer).Name = "file_show"
+	router.Get("/index.html", routingATestServer).Name = "index"
+
+	url, err := router.URLFor("file_show", map[string]string{"path": "css/my site.css"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := "/files/css/my%20site.css"
+	if url != expected {
+		t.Error("Expected URL:", url, "to equal:", expected)
+	}
+
+	url, err = router.URLFor("index", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected = "/index.html"
+	if url != expected {
+		t.Error("Expected URL:", url, "to equal:", expected)
+	}
+}
+
+// Build a table of several hundred routes, like a large API
+func benchmarkRoutes(router *Router) {
+	for i := 0; i < 100; i++ {
+		router.Get(fmt.Sprintf("/resource%d", i), routingATestServer)
+		router.Get(fmt.Sprintf("/resource%d/:id", i), routingATestServer)
+		router.Put(fmt.Sprintf("/resource%d/:id", i), routingATestServer)
+		router.Get(fmt.Sprintf("/resource%d/:id/children/:child", i), routingATestServer)
+	}
+}
+
+func benchmarkRouter(b *testing.B, router *Router) {
+	b.StopTimer()
+
+	benchmarkRoutes(router)
+	routerStack := new(Stack)
+	routerStack.Use(router.Middleware())
+	routerApp := routerStack.Compile(routingTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/resource99/123/children/456", nil)
+
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		routerApp(Env{"mango.request": &Request{request}})
+	}
+	b.StopTimer()
+}
+
+func BenchmarkRegexRouter(b *testing.B) {
+	benchmarkRouter(b, new(Router))
+}
+
+func BenchmarkTreeRouter(b *testing.B) {
+	benchmarkRouter(b, NewTreeRouter())
+}
+
+func TestRouterGroup(t *testing.T) {
+	router := new(Router)
+	router.Get("/", routingATestServer)
+
+	admin := router.Group("/admin", appendingMiddleware(" (admin)"))
+	admin.Get("/users/:id", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("User " + env.Param("id"))
+	}).Name = "admin_user"
+
+	reports := admin.Group("/reports", appendingMiddleware(" (reports)"))
+	reports.Get("/daily", func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Daily report")
+	})
+
+	routerStack := new(Stack)
+	routerStack.Use(admin.Middleware())
+	routerApp := routerStack.Compile(routingTestServer)
+
+	for _, test := range []struct {
+		url, expected string
+	}{
+		{"http://localhost:3000/", "Server A"},
+		{"http://localhost:3000/admin/users/123", "User 123 (admin)"},
+		{"http://localhost:3000/admin/reports/daily", "Daily report (reports) (admin)"},
+		{"http://localhost:3000/users/123", "Hello World!"},
+	} {
+		request, _ := http.NewRequest("GET", test.url, nil)
+		_, _, body := routerApp(Env{"mango.request": &Request{request}})
+
+		if string(body) != test.expected {
+			t.Error("Expected body of", test.url, "to equal:", test.expected, "got:", string(body))
+		}
+	}
+
+	url, err := router.URLFor("admin_user", map[string]string{"id": "123"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := "/admin/users/123"
+	if url != expected {
+		t.Error("Expected URL:", url, "to equal:", expected)
+	}
+}
@@ -0,0 +1,123 @@
+package mango
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Listen on Stack.Address (or 0.0.0.0:8000) and serve the stack.
+func (this *Stack) Run(app App) error {
+	return this.listen(app, func(server *http.Server) error {
+		fmt.Println("Starting Mango Stack On:", server.Addr)
+		return server.ListenAndServe()
+	})
+}
+
+// Listen on Stack.Address and serve the stack over HTTPS. certFile and
+// keyFile may be empty if Server.TLSConfig already holds a certificate.
+func (this *Stack) RunTLS(certFile, keyFile string, app App) error {
+	return this.listen(app, func(server *http.Server) error {
+		fmt.Println("Starting Mango Stack On:", server.Addr, "(TLS)")
+		return server.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// Serve the stack on a listener you've set up yourself, e.g. a Unix socket.
+func (this *Stack) Serve(listener net.Listener, app App) error {
+	return this.listen(app, func(server *http.Server) error {
+		fmt.Println("Starting Mango Stack On:", listener.Addr())
+		return server.Serve(listener)
+	})
+}
+
+func (this *Stack) listen(app App, start func(*http.Server) error) error {
+	if this.Address == "" {
+		this.Address = "0.0.0.0:8000"
+	}
+
+	server := this.Server
+	if server == nil {
+		server = new(http.Server)
+	}
+	if server.Addr == "" {
+		server.Addr = this.Address
+	}
+	server.Handler = this.Handler(app)
+
+	drained := make(chan struct{})
+	this.lock.Lock()
+	if this.shutdown {
+		// Shutdown was called before we got going
+		this.shutdown = false
+		this.lock.Unlock()
+		return http.ErrServerClosed
+	}
+	this.server = server
+	this.drained = drained
+	this.lock.Unlock()
+
+	err := start(server)
+
+	this.lock.Lock()
+	shuttingDown := this.server != server
+	if !shuttingDown {
+		// Stopped some other way, e.g. the address was in use
+		this.server, this.drained = nil, nil
+	}
+	this.shutdown = false
+	this.lock.Unlock()
+
+	if err == http.ErrServerClosed && shuttingDown {
+		// Don't return until in-flight requests have finished
+		<-drained
+	}
+	return err
+}
+
+// Gracefully stop a stack started with Run, RunTLS or Serve. No new
+// connections are accepted, and in-flight requests are given until ctx is
+// done to finish. Run, RunTLS and Serve then return http.ErrServerClosed.
+// If the stack hasn't started yet, the next Run, RunTLS or Serve returns
+// http.ErrServerClosed straight away.
+func (this *Stack) Shutdown(ctx context.Context) error {
+	this.lock.Lock()
+	server, drained := this.server, this.drained
+	this.server, this.drained = nil, nil
+	this.shutdown = true
+	this.lock.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	defer close(drained)
+	return server.Shutdown(ctx)
+}
+
+// Call Shutdown when one of the signals is received, giving in-flight
+// requests up to timeout to finish. Defaults to SIGINT and SIGTERM.
+func (this *Stack) ShutdownOnSignal(timeout time.Duration, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+
+	go func() {
+		<-received
+		signal.Stop(received)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := this.Shutdown(ctx); err != nil {
+			log.Println("Error shutting down Mango Stack:", err)
+		}
+	}()
+}
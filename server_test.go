@@ -0,0 +1,189 @@
+package mango
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack := new(Stack)
+	stack.Server = &http.Server{ReadTimeout: time.Second, MaxHeaderBytes: 4096}
+	served := make(chan error, 1)
+	go func() {
+		served <- stack.Serve(listener, helloWorld)
+	}()
+
+	response, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if string(body) != "Hello World!" {
+		t.Error("Expected body:", string(body), "to equal: \"Hello World!\"")
+	}
+
+	if stack.Server.ReadTimeout != time.Second || stack.Server.MaxHeaderBytes != 4096 {
+		t.Error("Expected the server config to be kept")
+	}
+
+	if err := stack.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if err := <-served; err != http.ErrServerClosed {
+		t.Error("Expected Serve to return http.ErrServerClosed, got:", err)
+	}
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan bool)
+	finish := make(chan bool)
+	slowTestServer := func(env Env) (Status, Headers, Body) {
+		started <- true
+		<-finish
+		return 200, Headers{}, Body("Finished")
+	}
+
+	stack := new(Stack)
+	served := make(chan error, 1)
+	go func() {
+		served <- stack.Serve(listener, slowTestServer)
+	}()
+
+	responded := make(chan string, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responded <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		responded <- string(body)
+	}()
+
+	<-started
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- stack.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-served:
+		t.Fatal("Expected Serve to wait for the in-flight request, got:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	finish <- true
+
+	if body := <-responded; body != "Finished" {
+		t.Error("Expected body:", body, "to equal: \"Finished\"")
+	}
+	if err := <-shutdown; err != nil {
+		t.Error(err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Error("Expected Serve to return http.ErrServerClosed, got:", err)
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mango")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "mango.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack := new(Stack)
+	go stack.Serve(listener, helloWorld)
+	defer stack.Shutdown(context.Background())
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	response, err := client.Get("http://mango/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if string(body) != "Hello World!" {
+		t.Error("Expected body:", string(body), "to equal: \"Hello World!\"")
+	}
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	stack := new(Stack)
+	if err := stack.Shutdown(context.Background()); err != nil {
+		t.Error("Expected no error, got:", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- stack.Serve(listener, helloWorld)
+	}()
+
+	select {
+	case err := <-served:
+		if err != http.ErrServerClosed {
+			t.Error("Expected Serve to return http.ErrServerClosed, got:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the earlier Shutdown to stop the stack")
+	}
+}
+
+func TestRunError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The address is in use
+	stack := new(Stack)
+	stack.Address = listener.Addr().String()
+	if err := stack.Run(helloWorld); err == nil || err == http.ErrServerClosed {
+		t.Fatal("Expected Run to fail, got:", err)
+	}
+
+	stack.lock.Lock()
+	server := stack.server
+	stack.lock.Unlock()
+	if server != nil {
+		t.Error("Expected the failed server to be forgotten")
+	}
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package mango
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownOnSignal(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack := new(Stack)
+	served := make(chan error, 1)
+	go func() {
+		served <- stack.Serve(listener, helloWorld)
+	}()
+
+	// Make sure the server is up before signalling
+	response, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	stack.ShutdownOnSignal(time.Second, syscall.SIGUSR1)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+
+	select {
+	case err := <-served:
+		if err != http.ErrServerClosed {
+			t.Error("Expected Serve to return http.ErrServerClosed, got:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the signal to shut the stack down")
+	}
+}
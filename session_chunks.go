@@ -0,0 +1,93 @@
+package mango
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The most bytes browsers keep in a cookie's name and value
+const defaultMaxCookieSize = 4096
+
+// Passed to SessionOptions.CookieTooLarge when a session doesn't fit in its
+// cookie.
+var ErrCookieTooLarge = errors.New("mango: session cookie too large")
+
+func (this *SessionOptions) maxCookieSize() int {
+	if this.MaxCookieSize > 0 {
+		return this.MaxCookieSize
+	}
+	return defaultMaxCookieSize
+}
+
+func (this *SessionOptions) cookieTooLarge(env Env, err error) {
+	if this.CookieTooLarge != nil {
+		this.CookieTooLarge(env, err)
+		return
+	}
+	env.Logger().Println("Error saving session:", err)
+}
+
+// The name of the i'th chunk of a session cookie: key, key_1, key_2, ...
+func chunkCookieName(key string, i int) string {
+	if i == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s_%d", key, i)
+}
+
+// Read the session cookie, joining its chunks back together
+func readCookieChunks(env Env, key string) (value string, chunks int) {
+	for {
+		chunk := sessionCookieValue(env, chunkCookieName(key, chunks))
+		if chunk == "" {
+			return value, chunks
+		}
+		value += chunk
+		chunks++
+	}
+}
+
+// Split a sealed session into cookie values which each fit in a cookie
+func splitCookieValue(value, key string, options *SessionOptions) ([]string, error) {
+	max := options.maxCookieSize()
+	if len(key)+len(value) <= max {
+		return []string{value}, nil
+	}
+
+	tooLarge := fmt.Errorf("%w: %d bytes, the most is %d", ErrCookieTooLarge, len(key)+len(value), max)
+	if !options.ChunkCookies {
+		return nil, tooLarge
+	}
+
+	// Leave room for the chunk number in the name
+	size := max - len(chunkCookieName(key, 999))
+	if size <= 0 {
+		return nil, tooLarge
+	}
+	var chunks []string
+	for len(value) > size {
+		chunks = append(chunks, value[:size])
+		value = value[size:]
+	}
+	return append(chunks, value), nil
+}
+
+// Write the sealed session to its cookie, split into chunks if needed, and
+// expire any chunks left over from a larger session.
+func writeSessionCookie(headers Headers, env Env, cookie sessionCookie, value string, options *SessionOptions) {
+	chunks, err := splitCookieValue(value, options.Key, options)
+	if err != nil {
+		options.cookieTooLarge(env, err)
+		return
+	}
+
+	for i, chunk := range chunks {
+		commitSession(headers, env, chunkCookieName(options.Key, i), options.Secret, chunk, options.Cookie)
+	}
+
+	expired := *options.Cookie
+	expired.MaxAge = -1
+	for i := len(chunks); i < cookie.chunks; i++ {
+		commitSession(headers, env, chunkCookieName(options.Key, i), options.Secret, "", &expired)
+	}
+}
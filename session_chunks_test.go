@@ -0,0 +1,98 @@
+package mango
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChunkedSessionCookies(t *testing.T) {
+	chunkTestServer := func(env Env) (Status, Headers, Body) {
+		if env.Request().Method == "POST" {
+			env.Session()["big"] = strings.Repeat("x", 5000)
+		} else {
+			if len(env.Session()["big"].(string)) != 5000 {
+				t.Error("Expected the chunked session to be joined back together")
+			}
+			delete(env.Session(), "big")
+			env.Session()["small"] = "y"
+		}
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	chunkStack := new(Stack)
+	chunkStack.Middleware(SessionsWithOptions(&SessionOptions{
+		Secret:        "my_secret",
+		Key:           "my_key",
+		MaxCookieSize: 1024,
+		ChunkCookies:  true,
+	}))
+	chunkApp := chunkStack.Compile(chunkTestServer)
+
+	request, _ := http.NewRequest("POST", "http://localhost:3000/", nil)
+	_, headers, _ := chunkApp(Env{"mango.request": &Request{request}})
+
+	cookies := (&http.Response{Header: http.Header(headers)}).Cookies()
+	if len(cookies) < 2 {
+		t.Fatal("Expected the session to be split across cookies, got:", len(cookies))
+	}
+	for i, cookie := range cookies {
+		if cookie.Name != chunkCookieName("my_key", i) {
+			t.Error("Expected cookie name:", chunkCookieName("my_key", i), "got:", cookie.Name)
+		}
+		if len(cookie.Name)+len(cookie.Value) > 1024 {
+			t.Error("Expected cookie", cookie.Name, "to fit in 1024 bytes, got:", len(cookie.Name)+len(cookie.Value))
+		}
+	}
+
+	// Once the session is small again, the extra chunks are expired
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	_, headers, _ = chunkApp(Env{"mango.request": &Request{request}})
+
+	shrunk := (&http.Response{Header: http.Header(headers)}).Cookies()
+	if len(shrunk) != len(cookies) {
+		t.Fatal("Expected", len(cookies), "cookies, got:", len(shrunk))
+	}
+	if shrunk[0].Value == "" || shrunk[0].MaxAge != 0 {
+		t.Error("Expected the session to fit in the first cookie, got:", shrunk[0])
+	}
+	for _, cookie := range shrunk[1:] {
+		if cookie.MaxAge != -1 {
+			t.Error("Expected cookie", cookie.Name, "to be expired")
+		}
+	}
+}
+
+func TestSessionCookieTooLarge(t *testing.T) {
+	largeTestServer := func(env Env) (Status, Headers, Body) {
+		env.Session()["big"] = strings.Repeat("x", 5000)
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	var tooLarge error
+	largeStack := new(Stack)
+	largeStack.Middleware(SessionsWithOptions(&SessionOptions{
+		Secret: "my_secret",
+		Key:    "my_key",
+		CookieTooLarge: func(env Env, err error) {
+			tooLarge = err
+		},
+	}))
+	largeApp := largeStack.Compile(largeTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, headers, _ := largeApp(Env{"mango.request": &Request{request}})
+
+	if !errors.Is(tooLarge, ErrCookieTooLarge) {
+		t.Error("Expected CookieTooLarge to be called with:", ErrCookieTooLarge, "got:", tooLarge)
+	}
+	if cookie := responseCookie(headers, "my_key"); cookie != nil {
+		t.Error("Expected no session cookie, got:", cookie)
+	}
+}
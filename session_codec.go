@@ -0,0 +1,67 @@
+package mango
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Converts sessions to and from the bytes kept in the cookie or store
+type SessionCodec interface {
+	Encode(session map[string]interface{}) ([]byte, error)
+	Decode(data []byte) (map[string]interface{}, error)
+}
+
+// The default codec. Every type stored in the session as an interface value
+// must be registered with gob.Register.
+type GobCodec struct{}
+
+func (this GobCodec) Encode(session map[string]interface{}) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	if err := gob.NewEncoder(buffer).Encode(sessionItemsFromMap(session)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (this GobCodec) Decode(data []byte) (map[string]interface{}, error) {
+	sis := sessionItems{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&sis); err != nil {
+		return nil, err
+	}
+	return sis.ToMap(), nil
+}
+
+// Keeps sessions as a JSON object, so they can be read by other languages.
+// Values come back as the types encoding/json decodes into, e.g. numbers
+// are float64, and structs are map[string]interface{}.
+type JSONCodec struct{}
+
+func (this JSONCodec) Encode(session map[string]interface{}) ([]byte, error) {
+	return json.Marshal(session)
+}
+
+func (this JSONCodec) Decode(data []byte) (map[string]interface{}, error) {
+	session := make(map[string]interface{})
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session == nil {
+		// The data was "null"
+		session = make(map[string]interface{})
+	}
+	return session, nil
+}
+
+// Read a number kept in the session, whichever type the codec gave back
+func sessionInt64(value interface{}) int64 {
+	switch value := value.(type) {
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
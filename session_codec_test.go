@@ -0,0 +1,112 @@
+package mango
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONCodec(t *testing.T) {
+	jsonTestServer := func(env Env) (Status, Headers, Body) {
+		counter, _ := env.Session()["counter"].(float64)
+		env.Session()["counter"] = counter + 1
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	jsonStack := new(Stack)
+	jsonStack.Middleware(SessionsWithOptions(&SessionOptions{
+		Secret:      "my_secret",
+		Key:         "my_key",
+		Codec:       JSONCodec{},
+		IdleTimeout: time.Hour,
+	}))
+	jsonApp := jsonStack.Compile(jsonTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, headers, _ := jsonApp(Env{"mango.request": &Request{request}})
+	cookie := responseCookie(headers, "my_key")
+	if cookie == nil {
+		t.Fatal("Expected the Set-Cookie header to be set")
+	}
+
+	// The cookie holds plain JSON, which other languages can read
+	data, ok := unsignCookie(cookie.Value, "my_secret")
+	if !ok {
+		t.Fatal("Expected the cookie to be signed with the secret")
+	}
+	if !strings.HasPrefix(data, `{"counter":1,`) {
+		t.Error("Expected the cookie to hold a JSON session, got:", data)
+	}
+
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(cookie)
+	env := Env{"mango.request": &Request{request}}
+	jsonApp(env)
+
+	if counter := env.Session()["counter"]; counter != float64(2) {
+		t.Error("Expected session[\"counter\"] to equal:", 2, "got:", counter)
+	}
+	if err := env.SessionError(); err != nil {
+		t.Error("Expected no session error, got:", err)
+	}
+}
+
+func TestSessionDecodeError(t *testing.T) {
+	decodeTestServer := func(env Env) (Status, Headers, Body) {
+		if env.SessionError() == nil {
+			t.Error("Expected the session error to be set")
+		}
+		if len(env.Session()) != 0 {
+			t.Error("Expected the session to be empty, got:", env.Session())
+		}
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	decodeStack := new(Stack)
+	decodeStack.Middleware(SessionsWithOptions(&SessionOptions{
+		Secret: "my_secret",
+		Key:    "my_key",
+		Codec:  JSONCodec{},
+	}))
+	decodeApp := decodeStack.Compile(decodeTestServer)
+
+	// Signed correctly, but not JSON
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(&http.Cookie{Name: "my_key", Value: signCookie("not json", "my_secret")})
+
+	buffer := new(bytes.Buffer)
+	decodeApp(Env{"mango.request": &Request{request}, "mango.logger": log.New(buffer, "", 0)})
+
+	if !strings.Contains(buffer.String(), "Error decoding session") {
+		t.Error("Expected the decode error to be logged, got:", buffer.String())
+	}
+}
+
+func TestSessionEncodeError(t *testing.T) {
+	type unregistered struct{ Name string }
+	encodeTestServer := func(env Env) (Status, Headers, Body) {
+		env.Session()["user"] = unregistered{"foo"}
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	encodeStack := new(Stack)
+	encodeStack.Middleware(Sessions("my_secret", "my_key", &CookieOptions{}))
+	encodeApp := encodeStack.Compile(encodeTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	buffer := new(bytes.Buffer)
+	_, headers, _ := encodeApp(Env{"mango.request": &Request{request}, "mango.logger": log.New(buffer, "", 0)})
+
+	if cookie := responseCookie(headers, "my_key"); cookie != nil {
+		t.Error("Expected no session cookie, got:", cookie)
+	}
+	if !strings.Contains(buffer.String(), "Error encoding session") {
+		t.Error("Expected the encode error to be logged, got:", buffer.String())
+	}
+}
@@ -0,0 +1,78 @@
+package mango
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// Protects the data in session cookies. Without keys, cookies are signed
+// with the secret. With keys, they're encrypted with the first key.
+type cookieSealer struct {
+	secret string
+	keys   []cipher.AEAD
+}
+
+func newCookieSealer(secret string, keys []string) *cookieSealer {
+	sealer := &cookieSealer{secret: secret}
+	for _, key := range keys {
+		// Hash the key so any string can be used as a 256-bit AES key
+		sum := sha256.Sum256([]byte(key))
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		sealer.keys = append(sealer.keys, aead)
+	}
+	return sealer
+}
+
+func (this *cookieSealer) seal(data string) (string, error) {
+	if len(this.keys) == 0 {
+		return signCookie(data, this.secret), nil
+	}
+
+	aead := this.keys[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(data), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open a sealed cookie, returning its data, and whether it was sealed the
+// way seal would do it now, rather than with an old key or just signed.
+func (this *cookieSealer) open(value string) (data string, current bool, ok bool) {
+	if strings.Contains(value, "/") {
+		// Signed, rather than encrypted. Without a secret, anyone could
+		// sign one, so don't fall back to them.
+		if this.secret == "" && len(this.keys) > 0 {
+			return "", false, false
+		}
+		data, ok = unsignCookie(value, this.secret)
+		return data, len(this.keys) == 0, ok
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", false, false
+	}
+	for i, aead := range this.keys {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+			return string(plaintext), i == 0, true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,113 @@
+package mango
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCookieSealer(t *testing.T) {
+	sealer := newCookieSealer("", []string{"new key", "old key"})
+
+	sealed, err := sealer.seal("secret data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(decode64(sealed), "secret data") {
+		t.Error("Expected the cookie to be encrypted, got:", sealed)
+	}
+
+	data, current, ok := sealer.open(sealed)
+	if !ok || !current || data != "secret data" {
+		t.Error("Expected to open the cookie with the current key, got:", data, current, ok)
+	}
+
+	// Cookies sealed with an old key are still accepted
+	old, _ := newCookieSealer("", []string{"old key"}).seal("old data")
+	data, current, ok = sealer.open(old)
+	if !ok || current || data != "old data" {
+		t.Error("Expected to open the cookie with the old key, got:", data, current, ok)
+	}
+
+	// Tampering is detected
+	tampered := []byte(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, ok := sealer.open(string(tampered)); ok {
+		t.Error("Expected a tampered cookie to be rejected")
+	}
+
+	unknown, _ := newCookieSealer("", []string{"unknown key"}).seal("data")
+	if _, _, ok := sealer.open(unknown); ok {
+		t.Error("Expected a cookie with an unknown key to be rejected")
+	}
+
+	// Without a secret, signed cookies can't be trusted
+	if _, _, ok := sealer.open(signCookie("data", "")); ok {
+		t.Error("Expected a signed cookie to be rejected")
+	}
+}
+
+func TestEncryptedSessions(t *testing.T) {
+	sessionsTestServer := func(env Env) (Status, Headers, Body) {
+		counter, _ := env.Session()["counter"].(int)
+		env.Session()["counter"] = counter + 1
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	sessionsStack := new(Stack)
+	sessionsStack.Middleware(SessionsWithOptions(&SessionOptions{Secret: "my_secret", Keys: []string{"new key", "old key"}, Key: "my_key"}))
+	sessionsApp := sessionsStack.Compile(sessionsTestServer)
+
+	for _, value := range []string{
+		// Signed with the secret, from before encryption was turned on
+		encodeCookie(map[string]interface{}{"counter": 1}, "my_secret"),
+		// Encrypted with the old key
+		func() string {
+			value, _ := newCookieSealer("", []string{"old key"}).seal(encodeGob(map[string]interface{}{"counter": 1}))
+			return value
+		}(),
+	} {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		request.AddCookie(&http.Cookie{Name: "my_key", Value: value})
+		_, headers, _ := sessionsApp(Env{"mango.request": &Request{request}})
+
+		cookie := responseCookie(headers, "my_key")
+		if cookie == nil {
+			t.Fatal("Expected the Set-Cookie header to be set")
+		}
+
+		// Re-issued with the new key
+		data, current, ok := newCookieSealer("", []string{"new key"}).open(cookie.Value)
+		if !ok || !current {
+			t.Fatal("Expected the cookie to be encrypted with the new key, got:", cookie.Value)
+		}
+
+		session := decodeGob(data)
+		if session["counter"] != 2 {
+			t.Error("Expected session[\"counter\"] to equal: 2, got:", session["counter"])
+		}
+	}
+}
+
+func TestEncryptedSessionsUnchanged(t *testing.T) {
+	sessionsTestServer := func(env Env) (Status, Headers, Body) {
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	sessionsStack := new(Stack)
+	sessionsStack.Middleware(SessionsWithOptions(&SessionOptions{Keys: []string{"new key"}, Key: "my_key"}))
+	sessionsApp := sessionsStack.Compile(sessionsTestServer)
+
+	value, _ := newCookieSealer("", []string{"new key"}).seal(encodeGob(map[string]interface{}{"counter": 1}))
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(&http.Cookie{Name: "my_key", Value: value})
+	_, headers, _ := sessionsApp(Env{"mango.request": &Request{request}})
+
+	// Encryption isn't deterministic, so make sure an unchanged session
+	// isn't re-issued anyway
+	if headers.Get("Set-Cookie") != "" {
+		t.Error("Expected the cookie not to change, got:", headers.Get("Set-Cookie"))
+	}
+}
@@ -0,0 +1,216 @@
+package mango
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Somewhere to keep sessions on the server, used with SessionOptions.Store.
+// The session data is already encoded when it's saved.
+type SessionStore interface {
+	// Load the data saved for a session. Returns nil if there's none, or
+	// it has expired.
+	Load(id string) ([]byte, error)
+	Save(id string, data []byte) error
+	Delete(id string) error
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func validSessionID(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == 32
+}
+
+var errInvalidSessionID = errors.New("mango: invalid session id")
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// Keeps sessions in memory, so they're lost on restart and aren't shared
+// between processes. Sessions expire once they've gone unused for ttl.
+type MemoryStore struct {
+	ttl       time.Duration
+	sessions  map[string]memorySession
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// Make a MemoryStore. A ttl of 0 means sessions never expire.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, sessions: make(map[string]memorySession), lastSweep: time.Now()}
+}
+
+func (this *MemoryStore) expired(session memorySession, now time.Time) bool {
+	return this.ttl > 0 && now.After(session.expires)
+}
+
+func (this *MemoryStore) Load(id string) ([]byte, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	session, ok := this.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if this.expired(session, time.Now()) {
+		delete(this.sessions, id)
+		return nil, nil
+	}
+	return append([]byte{}, session.data...), nil
+}
+
+func (this *MemoryStore) Save(id string, data []byte) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	now := time.Now()
+	this.sessions[id] = memorySession{data: append([]byte{}, data...), expires: now.Add(this.ttl)}
+
+	// Evict expired sessions every so often, so abandoned ones don't pile up
+	if this.ttl > 0 && now.Sub(this.lastSweep) > this.ttl {
+		for id, session := range this.sessions {
+			if this.expired(session, now) {
+				delete(this.sessions, id)
+			}
+		}
+		this.lastSweep = now
+	}
+	return nil
+}
+
+func (this *MemoryStore) Delete(id string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.sessions, id)
+	return nil
+}
+
+// Keeps sessions as files in a directory. Sessions expire once they've
+// gone unused for ttl.
+type FileStore struct {
+	dir       string
+	ttl       time.Duration
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// Make a FileStore, creating the directory if needed. A ttl of 0 means
+// sessions never expire.
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, ttl: ttl, lastSweep: time.Now()}, nil
+}
+
+func (this *FileStore) path(id string) (string, error) {
+	// IDs come from cookies, so make sure they can't point anywhere else
+	if !validSessionID(id) {
+		return "", errInvalidSessionID
+	}
+	return filepath.Join(this.dir, id), nil
+}
+
+func (this *FileStore) expired(info os.FileInfo, now time.Time) bool {
+	return this.ttl > 0 && now.Sub(info.ModTime()) > this.ttl
+}
+
+func (this *FileStore) Load(id string) ([]byte, error) {
+	path, err := this.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if this.expired(info, time.Now()) {
+		return nil, this.Delete(id)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (this *FileStore) Save(id string, data []byte) error {
+	path, err := this.path(id)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a session is never half-written
+	file, err := ioutil.TempFile(this.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	this.sweep()
+	return nil
+}
+
+// Remove expired sessions every so often, so abandoned ones don't pile up
+func (this *FileStore) sweep() {
+	now := time.Now()
+	this.lock.Lock()
+	if this.ttl == 0 || now.Sub(this.lastSweep) <= this.ttl {
+		this.lock.Unlock()
+		return
+	}
+	this.lastSweep = now
+	this.lock.Unlock()
+
+	infos, err := ioutil.ReadDir(this.dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if validSessionID(info.Name()) && this.expired(info, now) {
+			os.Remove(filepath.Join(this.dir, info.Name()))
+		}
+	}
+}
+
+func (this *FileStore) Delete(id string) error {
+	path, err := this.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
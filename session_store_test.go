@@ -0,0 +1,105 @@
+package mango
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	id, err := newSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := store.Load(id)
+	if data != nil || err != nil {
+		t.Error("Expected no session, got:", data, err)
+	}
+
+	if err := store.Save(id, []byte("foo")); err != nil {
+		t.Error(err)
+	}
+
+	data, err = store.Load(id)
+	if string(data) != "foo" || err != nil {
+		t.Error("Expected session to equal: \"foo\", got:", string(data), err)
+	}
+
+	if err := store.Delete(id); err != nil {
+		t.Error(err)
+	}
+
+	data, err = store.Load(id)
+	if data != nil || err != nil {
+		t.Error("Expected no session after deleting, got:", data, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testSessionStore(t, NewMemoryStore(time.Hour))
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore(20 * time.Millisecond)
+	store.Save("expired", []byte("foo"))
+
+	time.Sleep(30 * time.Millisecond)
+	store.Save("fresh", []byte("bar"))
+
+	// The expired session is evicted when the fresh one is saved
+	if _, found := store.sessions["expired"]; found {
+		t.Error("Expected the expired session to be evicted")
+	}
+
+	if data, _ := store.Load("fresh"); string(data) != "bar" {
+		t.Error("Expected session to equal: \"bar\", got:", string(data))
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mango")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSessionStore(t, store)
+
+	if _, err := store.Load("../../etc/passwd"); err != errInvalidSessionID {
+		t.Error("Expected an invalid session id error, got:", err)
+	}
+}
+
+func TestFileStoreExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mango")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := newSessionID()
+	store.Save(id, []byte("foo"))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(store.dir, id), old, old)
+
+	data, err := store.Load(id)
+	if data != nil || err != nil {
+		t.Error("Expected the session to have expired, got:", data, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(store.dir, id)); !os.IsNotExist(err) {
+		t.Error("Expected the expired session to be removed")
+	}
+}
@@ -5,13 +5,13 @@ import (
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/gob"
 	"fmt"
 	"hash"
 	"io/ioutil"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 )
 
 type sessionItem struct {
@@ -63,13 +63,11 @@ func verifyCookie(data, secret, sum string) bool {
 }
 
 func decodeGob(value string) (result map[string]interface{}) {
-	buffer := bytes.NewBufferString(value)
-
-	decoder := gob.NewDecoder(buffer)
-	sis := sessionItems{}
-	decoder.Decode(&sis)
-
-	return sis.ToMap()
+	result, err := GobCodec{}.Decode([]byte(value))
+	if err != nil {
+		return make(map[string]interface{})
+	}
+	return result
 }
 
 // Due to a bug in golang where when using
@@ -88,30 +86,29 @@ func decode64(value string) (result string) {
 	return string(decoded)
 }
 
-func decodeCookie(value, secret string) (cookie map[string]interface{}) {
-	cookie = make(map[string]interface{})
-
+// Check the signature on a cookie value, returning the data it signs
+func unsignCookie(value, secret string) (data string, ok bool) {
 	split := strings.Split(string(value), "/")
 
 	if len(split) < 2 {
-		return cookie
+		return "", false
 	}
 
-	data := decode64(split[0])
+	data = decode64(split[0])
 	sum := decode64(split[1])
-	if verifyCookie(data, secret, sum) {
-		cookie = decodeGob(data)
-	}
+	return data, verifyCookie(data, secret, sum)
+}
 
-	return cookie
+func decodeCookie(value, secret string) (cookie map[string]interface{}) {
+	if data, ok := unsignCookie(value, secret); ok {
+		return decodeGob(data)
+	}
+	return make(map[string]interface{})
 }
 
 func encodeGob(value map[string]interface{}) (result string) {
-	buffer := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buffer)
-	sis := sessionItemsFromMap(value)
-	encoder.Encode(sis)
-	return buffer.String()
+	data, _ := GobCodec{}.Encode(value)
+	return string(data)
 }
 
 // Due to a bug in golang where when using
@@ -130,20 +127,12 @@ func encode64(value string) (result string) {
 	return dePad64(buffer.String())
 }
 
-func encodeCookie(value map[string]interface{}, secret string) (cookie string) {
-	data := encodeGob(value)
-
+func signCookie(data, secret string) (cookie string) {
 	return fmt.Sprintf("%s/%s", encode64(data), encode64(hashCookie(data, secret)))
 }
 
-func prepareSession(env Env, key, secret string) {
-	value := sessionCookieValue(env, key)
-	if value == "" {
-		// Didn't find a session to decode
-		env["mango.session"] = make(map[string]interface{})
-		return
-	}
-	env["mango.session"] = decodeCookie(value, secret)
+func encodeCookie(value map[string]interface{}, secret string) (cookie string) {
+	return signCookie(encodeGob(value), secret)
 }
 
 func commitSession(headers Headers, env Env, key, secret string, newValue string, options *CookieOptions) {
@@ -155,7 +144,13 @@ func commitSession(headers Headers, env Env, key, secret string, newValue string
 	cookie.MaxAge = options.MaxAge
 	cookie.Secure = options.Secure
 	cookie.HttpOnly = options.HttpOnly
-	headers.Add("Set-Cookie", cookie.String())
+	cookie.SameSite = options.SameSite
+	cookie.Expires = options.Expires
+	value := cookie.String()
+	if options.Partitioned {
+		value += "; Partitioned"
+	}
+	headers.Add("Set-Cookie", value)
 }
 
 func sessionCookieValue(env Env, key string) (value string) {
@@ -169,39 +164,315 @@ func sessionCookieValue(env Env, key string) (value string) {
 
 }
 
-func cookieChanged(env Env, key, secret string) string {
-	oldCookieValue := sessionCookieValue(env, key)
-	value := env["mango.session"].(map[string]interface{})
-
-	// old and new both are empty
-	if oldCookieValue == "" && len(value) == 0 {
-		return ""
-	}
-
-	newCookieValue := encodeCookie(value, secret)
-	if oldCookieValue == newCookieValue {
-		return ""
-	}
-	return newCookieValue
-}
-
 type CookieOptions struct {
 	Domain   string
 	Path     string
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	// http.SameSiteLaxMode, http.SameSiteStrictMode or
+	// http.SameSiteNoneMode. Browsers need None cookies to be Secure.
+	SameSite http.SameSite
+	// Ignored by browsers when MaxAge is set
+	Expires time.Time
+	// Keep the cookie separately for each top-level site (CHIPS). Must be
+	// Secure.
+	Partitioned bool
+}
+
+// Check the options meet the rules browsers enforce for the cookie name
+func (this *CookieOptions) validate(name string) error {
+	if strings.HasPrefix(name, "__Secure-") && !this.Secure {
+		return fmt.Errorf("mango: cookie %q must be Secure", name)
+	}
+	if strings.HasPrefix(name, "__Host-") {
+		if !this.Secure || this.Path != "/" || this.Domain != "" {
+			return fmt.Errorf("mango: cookie %q must be Secure, with Path \"/\" and no Domain", name)
+		}
+	}
+	if this.Partitioned && !this.Secure {
+		return fmt.Errorf("mango: partitioned cookie %q must be Secure", name)
+	}
+	if this.SameSite == http.SameSiteNoneMode && !this.Secure {
+		return fmt.Errorf("mango: SameSite=None cookie %q must be Secure", name)
+	}
+	return nil
+}
+
+type SessionOptions struct {
+	// The secret used to sign the session cookie
+	Secret string
+	// If set, session cookies are encrypted (with AES-GCM) as well as
+	// authenticated, using the first key. Cookies made with the other keys,
+	// or signed with Secret, are still accepted, and re-issued using the
+	// first key. To rotate keys, add a new one to the front of the list.
+	Keys []string
+	// The name of the session cookie
+	Key    string
+	Cookie *CookieOptions
+	// Where sessions are kept. If nil, the whole session is kept in the
+	// cookie, so old cookies can't be revoked, only timed out. Otherwise
+	// the cookie only holds a signed session ID.
+	Store SessionStore
+	// If set, sessions are reset once they're older than AbsoluteTimeout,
+	// or have gone unused for longer than IdleTimeout. The times are kept
+	// in the session data, so they're protected like the rest of it.
+	AbsoluteTimeout time.Duration
+	IdleTimeout     time.Duration
+	// How sessions are encoded. Defaults to GobCodec.
+	Codec SessionCodec
+	// The most bytes of name and value to put in one cookie. Defaults to
+	// 4096, which is what browsers keep.
+	MaxCookieSize int
+	// If set, sessions too large for one cookie are split across cookies
+	// named Key, Key_1, Key_2, ...
+	ChunkCookies bool
+	// Called with ErrCookieTooLarge when a session is too large for its
+	// cookie. The session isn't saved. Defaults to logging the error.
+	CookieTooLarge func(env Env, err error)
+}
+
+// The keys session times are kept under in the session data
+const (
+	sessionCreatedKey = "mango.created"
+	sessionSeenKey    = "mango.seen"
+)
+
+func (this *SessionOptions) timed() bool {
+	return this.AbsoluteTimeout > 0 || this.IdleTimeout > 0
+}
+
+// Decode the session data into the env, resetting the session if it has
+// timed out. If it can't be decoded, the session starts empty, and the
+// error is logged and available from env.SessionError().
+func loadSession(env Env, data string, options *SessionOptions) {
+	session, err := options.Codec.Decode([]byte(data))
+	if err != nil {
+		env.Logger().Println("Error decoding session:", err)
+		env["mango.session_error"] = err
+		session = make(map[string]interface{})
+	}
+	env["mango.session"] = session
+	prepareFlashes(env)
+	if !options.timed() {
+		return
+	}
+
+	created := sessionInt64(session[sessionCreatedKey])
+	seen := sessionInt64(session[sessionSeenKey])
+	delete(session, sessionCreatedKey)
+	delete(session, sessionSeenKey)
+	env["mango.session_created"] = created
+
+	now := time.Now()
+	if options.AbsoluteTimeout > 0 && now.After(time.Unix(created, 0).Add(options.AbsoluteTimeout)) ||
+		options.IdleTimeout > 0 && now.After(time.Unix(seen, 0).Add(options.IdleTimeout)) {
+		env.ResetSession()
+	}
+}
+
+// Encode the session from the env, along with its times if needed
+func sessionData(env Env, options *SessionOptions) (string, error) {
+	session := env.Session()
+	if !options.timed() || len(session) == 0 {
+		data, err := options.Codec.Encode(session)
+		return string(data), err
+	}
+
+	now := time.Now().Unix()
+	created, _ := env["mango.session_created"].(int64)
+	if created == 0 || env["mango.session_regenerate"] == true {
+		created = now
+	}
+
+	timed := make(map[string]interface{}, len(session)+2)
+	for key, value := range session {
+		timed[key] = value
+	}
+	timed[sessionCreatedKey] = created
+	timed[sessionSeenKey] = now
+	data, err := options.Codec.Encode(timed)
+	return string(data), err
 }
 
 func Sessions(secret, key string, options *CookieOptions) Middleware {
+	return SessionsWithOptions(&SessionOptions{Secret: secret, Key: key, Cookie: options})
+}
+
+func SessionsWithOptions(options *SessionOptions) Middleware {
+	if options.Cookie == nil {
+		options.Cookie = new(CookieOptions)
+	}
+	if err := options.Cookie.validate(options.Key); err != nil {
+		panic(err)
+	}
+	if options.Codec == nil {
+		options.Codec = GobCodec{}
+	}
+	sealer := newCookieSealer(options.Secret, options.Keys)
+
 	return func(env Env, app App) (status Status, headers Headers, body Body) {
-		prepareSession(env, key, secret)
+		var cookie sessionCookie
+		if options.Store != nil {
+			cookie = prepareStoredSession(env, sealer, options)
+		} else {
+			cookie = prepareSession(env, sealer, options)
+		}
+
 		status, headers, body = app(env)
-		newValue := cookieChanged(env, key, secret)
-		if newValue == "" {
+		if headers == nil {
+			headers = Headers{}
+		}
+
+		if options.Store != nil {
+			commitStoredSession(headers, env, cookie, sealer, options)
+		} else {
+			commitCookieSession(headers, env, cookie, sealer, options)
+		}
+		return
+	}
+}
+
+// What was in the session cookie sent with the request
+type sessionCookie struct {
+	value string
+	data  string
+	// Whether the cookie was made with the current secret or key
+	current bool
+	// How many cookies it was split across
+	chunks int
+}
+
+func readSessionCookie(env Env, sealer *cookieSealer, options *SessionOptions) (cookie sessionCookie, ok bool) {
+	if options.ChunkCookies {
+		cookie.value, cookie.chunks = readCookieChunks(env, options.Key)
+	} else {
+		cookie.value = sessionCookieValue(env, options.Key)
+	}
+	if cookie.value == "" {
+		return cookie, false
+	}
+	cookie.data, cookie.current, ok = sealer.open(cookie.value)
+	return cookie, ok
+}
+
+func prepareSession(env Env, sealer *cookieSealer, options *SessionOptions) sessionCookie {
+	delete(env, "mango.session_created")
+	delete(env, "mango.session_regenerate")
+	delete(env, "mango.session_error")
+	delete(env, "mango.flashes")
+
+	cookie, ok := readSessionCookie(env, sealer, options)
+	if ok {
+		loadSession(env, cookie.data, options)
+	} else {
+		// Didn't find a session to decode
+		env["mango.session"] = make(map[string]interface{})
+	}
+	return cookie
+}
+
+func commitCookieSession(headers Headers, env Env, cookie sessionCookie, sealer *cookieSealer, options *SessionOptions) {
+	session := env.Session()
+
+	// old and new both are empty
+	if cookie.value == "" && len(session) == 0 {
+		return
+	}
+
+	data, err := sessionData(env, options)
+	if err != nil {
+		env.Logger().Println("Error encoding session:", err)
+		return
+	}
+	if cookie.current && cookie.data == data && env["mango.session_regenerate"] != true {
+		return
+	}
+
+	value, err := sealer.seal(data)
+	if err != nil {
+		env.Logger().Println("Error saving session:", err)
+		return
+	}
+	writeSessionCookie(headers, env, cookie, value, options)
+}
+
+func prepareStoredSession(env Env, sealer *cookieSealer, options *SessionOptions) sessionCookie {
+	env["mango.session"] = make(map[string]interface{})
+	delete(env, "mango.session_id")
+	delete(env, "mango.session_created")
+	delete(env, "mango.session_regenerate")
+	delete(env, "mango.session_error")
+	delete(env, "mango.flashes")
+
+	cookie, ok := readSessionCookie(env, sealer, options)
+	if !ok {
+		return cookie
+	}
+
+	data, err := options.Store.Load(cookie.data)
+	if err != nil {
+		env.Logger().Println("Error loading session:", err)
+		return cookie
+	}
+	if data == nil {
+		// Expired or deleted
+		return cookie
+	}
+
+	env["mango.session_id"] = cookie.data
+	loadSession(env, string(data), options)
+	return cookie
+}
+
+func commitStoredSession(headers Headers, env Env, cookie sessionCookie, sealer *cookieSealer, options *SessionOptions) {
+	session := env.Session()
+	id, _ := env["mango.session_id"].(string)
+
+	if id != "" && (len(session) == 0 || env["mango.session_regenerate"] == true) {
+		if err := options.Store.Delete(id); err != nil {
+			env.Logger().Println("Error deleting session:", err)
+		}
+		if len(session) == 0 {
+			expired := *options.Cookie
+			expired.MaxAge = -1
+			commitSession(headers, env, options.Key, options.Secret, "", &expired)
 			return
 		}
-		commitSession(headers, env, key, secret, newValue, options)
+		id = ""
+	}
+
+	if len(session) == 0 {
+		return
+	}
+
+	newID := id
+	if newID == "" {
+		var err error
+		if newID, err = newSessionID(); err != nil {
+			env.Logger().Println("Error creating session:", err)
+			return
+		}
+	}
+
+	data, err := sessionData(env, options)
+	if err != nil {
+		env.Logger().Println("Error encoding session:", err)
 		return
 	}
+
+	// Always save, so the store sees the session is still in use
+	if err := options.Store.Save(newID, []byte(data)); err != nil {
+		env.Logger().Println("Error saving session:", err)
+		return
+	}
+
+	if newID != id || !cookie.current {
+		value, err := sealer.seal(newID)
+		if err != nil {
+			env.Logger().Println("Error saving session:", err)
+			return
+		}
+		writeSessionCookie(headers, env, cookie, value, options)
+	}
 }
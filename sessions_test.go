@@ -1,9 +1,11 @@
 package mango
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSessionEncodingDecoding(t *testing.T) {
@@ -94,3 +96,258 @@ func BenchmarkSessions(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+// Find a cookie set in the response headers
+func responseCookie(headers Headers, name string) *http.Cookie {
+	response := http.Response{Header: http.Header(headers)}
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestStoredSessions(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	sessionsTestServer := func(env Env) (Status, Headers, Body) {
+		if env.Request().URL.Path == "/logout" {
+			delete(env.Session(), "counter")
+		} else {
+			counter, _ := env.Session()["counter"].(int)
+			env.Session()["counter"] = counter + 1
+		}
+		return 200, Headers{}, Body(fmt.Sprint(env.Session()["counter"]))
+	}
+
+	// Compile the stack
+	sessionsStack := new(Stack)
+	sessionsStack.Middleware(SessionsWithOptions(&SessionOptions{Secret: "my_secret", Key: "my_key", Store: store}))
+	sessionsApp := sessionsStack.Compile(sessionsTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, headers, body := sessionsApp(Env{"mango.request": &Request{request}})
+
+	if string(body) != "1" {
+		t.Error("Expected body to equal: \"1\", got:", string(body))
+	}
+
+	// The cookie only holds the session's ID
+	cookie := responseCookie(headers, "my_key")
+	if cookie == nil {
+		t.Fatal("Expected the Set-Cookie header to be set")
+	}
+	id, ok := unsignCookie(cookie.Value, "my_secret")
+	if !ok || !validSessionID(id) {
+		t.Error("Expected the cookie to hold a signed session id, got:", cookie.Value)
+	}
+
+	// The session is loaded from the store on the next request
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(cookie)
+	_, headers, body = sessionsApp(Env{"mango.request": &Request{request}})
+
+	if string(body) != "2" {
+		t.Error("Expected body to equal: \"2\", got:", string(body))
+	}
+
+	if headers.Get("Set-Cookie") != "" {
+		t.Error("Expected the cookie not to change, got:", headers.Get("Set-Cookie"))
+	}
+
+	// Emptying the session removes it from the store
+	request, _ = http.NewRequest("GET", "http://localhost:3000/logout", nil)
+	request.AddCookie(cookie)
+	_, headers, _ = sessionsApp(Env{"mango.request": &Request{request}})
+
+	if data, _ := store.Load(id); data != nil {
+		t.Error("Expected the session to be deleted, got:", data)
+	}
+
+	if !strings.Contains(headers.Get("Set-Cookie"), "Max-Age=0") {
+		t.Error("Expected the cookie to be expired, got:", headers.Get("Set-Cookie"))
+	}
+
+	// A forged session id is ignored
+	request, _ = http.NewRequest("GET", "http://localhost:3000/", nil)
+	request.AddCookie(&http.Cookie{Name: "my_key", Value: signCookie(id, "wrong_secret")})
+	_, _, body = sessionsApp(Env{"mango.request": &Request{request}})
+
+	if string(body) != "1" {
+		t.Error("Expected a new session, got:", string(body))
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	sessionsTestServer := func(env Env) (Status, Headers, Body) {
+		counter, _ := env.Session()["counter"].(int)
+		env.Session()["counter"] = counter + 1
+		return 200, Headers{}, Body(fmt.Sprint(counter + 1))
+	}
+
+	// Compile the stack
+	sessionsStack := new(Stack)
+	sessionsStack.Middleware(SessionsWithOptions(&SessionOptions{
+		Secret:          "my_secret",
+		Key:             "my_key",
+		AbsoluteTimeout: time.Hour,
+		IdleTimeout:     time.Minute,
+	}))
+	sessionsApp := sessionsStack.Compile(sessionsTestServer)
+
+	now := time.Now()
+	for _, test := range []struct {
+		created, seen time.Time
+		expected      string
+	}{
+		{now.Add(-30 * time.Minute), now.Add(-30 * time.Second), "2"},
+		// Too old
+		{now.Add(-2 * time.Hour), now.Add(-30 * time.Second), "1"},
+		// Idle for too long
+		{now.Add(-30 * time.Minute), now.Add(-2 * time.Minute), "1"},
+	} {
+		session := map[string]interface{}{
+			"counter":         1,
+			sessionCreatedKey: test.created.Unix(),
+			sessionSeenKey:    test.seen.Unix(),
+		}
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		request.AddCookie(&http.Cookie{Name: "my_key", Value: encodeCookie(session, "my_secret")})
+		_, headers, body := sessionsApp(Env{"mango.request": &Request{request}})
+
+		if string(body) != test.expected {
+			t.Error("Expected body to equal:", test.expected, "got:", string(body))
+		}
+
+		cookie := responseCookie(headers, "my_key")
+		if cookie == nil {
+			t.Fatal("Expected the Set-Cookie header to be set")
+		}
+
+		// The times are updated in the new cookie
+		value := decodeCookie(cookie.Value, "my_secret")
+		if seen := value[sessionSeenKey].(int64); seen < now.Unix() {
+			t.Error("Expected the last seen time to be updated, got:", time.Unix(seen, 0))
+		}
+		if test.expected == "1" && value[sessionCreatedKey].(int64) < now.Unix() {
+			t.Error("Expected a reset session to have a new created time, got:", time.Unix(value[sessionCreatedKey].(int64), 0))
+		}
+	}
+}
+
+func TestRegenerateSession(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	sessionsTestServer := func(env Env) (Status, Headers, Body) {
+		switch env.Request().URL.Path {
+		case "/login":
+			env.RegenerateSession()
+			env.Session()["user"] = "foo"
+		case "/logout":
+			env.ResetSession()
+		}
+		user, _ := env.Session()["user"].(string)
+		return 200, Headers{}, Body(user)
+	}
+
+	// Compile the stack
+	sessionsStack := new(Stack)
+	sessionsStack.Middleware(SessionsWithOptions(&SessionOptions{Secret: "my_secret", Key: "my_key", Store: store}))
+	sessionsApp := sessionsStack.Compile(sessionsTestServer)
+
+	// An existing, anonymous session
+	oldID, _ := newSessionID()
+	store.Save(oldID, []byte(encodeGob(map[string]interface{}{"cart": "full"})))
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/login", nil)
+	request.AddCookie(&http.Cookie{Name: "my_key", Value: signCookie(oldID, "my_secret")})
+	_, headers, _ := sessionsApp(Env{"mango.request": &Request{request}})
+
+	cookie := responseCookie(headers, "my_key")
+	if cookie == nil {
+		t.Fatal("Expected the Set-Cookie header to be set")
+	}
+
+	newID, _ := unsignCookie(cookie.Value, "my_secret")
+	if newID == oldID {
+		t.Error("Expected a new session id")
+	}
+
+	if data, _ := store.Load(oldID); data != nil {
+		t.Error("Expected the old session to be deleted")
+	}
+
+	data, _ := store.Load(newID)
+	if session := decodeGob(string(data)); session["cart"] != "full" || session["user"] != "foo" {
+		t.Error("Expected the session data to be kept, got:", session)
+	}
+
+	// Logging out empties the session
+	request, _ = http.NewRequest("GET", "http://localhost:3000/logout", nil)
+	request.AddCookie(cookie)
+	_, headers, body := sessionsApp(Env{"mango.request": &Request{request}})
+
+	if string(body) != "" {
+		t.Error("Expected an empty session, got:", string(body))
+	}
+
+	if data, _ := store.Load(newID); data != nil {
+		t.Error("Expected the session to be deleted")
+	}
+
+	if !strings.Contains(headers.Get("Set-Cookie"), "Max-Age=0") {
+		t.Error("Expected the cookie to be expired, got:", headers.Get("Set-Cookie"))
+	}
+}
+
+func TestCookieOptions(t *testing.T) {
+	cookieTestServer := func(env Env) (Status, Headers, Body) {
+		env.Session()["user"] = "foo"
+		return 200, Headers{}, Body("Hello World!")
+	}
+
+	// Compile the stack
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	cookieStack := new(Stack)
+	cookieStack.Middleware(Sessions("my_secret", "__Host-my_key", &CookieOptions{
+		Path:        "/",
+		Secure:      true,
+		SameSite:    http.SameSiteNoneMode,
+		Expires:     expires,
+		Partitioned: true,
+	}))
+	cookieApp := cookieStack.Compile(cookieTestServer)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	_, headers, _ := cookieApp(Env{"mango.request": &Request{request}})
+
+	header := headers.Get("Set-Cookie")
+	for _, expected := range []string{"__Host-my_key=", "; Path=/", "; Expires=Wed, 02 Jan 2030 03:04:05 GMT", "; Secure", "; SameSite=None", "; Partitioned"} {
+		if !strings.Contains(header, expected) {
+			t.Error("Expected cookie", header, "to contain:", expected)
+		}
+	}
+}
+
+func TestCookieOptionsValidation(t *testing.T) {
+	invalid := map[string]*CookieOptions{
+		"__Secure-my_key": {},
+		"__Host-my_key":   {Secure: true, Path: "/", Domain: "my.domain.com"},
+		"__Host-other":    {Secure: true, Path: "/things"},
+		"partitioned":     {Partitioned: true},
+		"same_site_none":  {SameSite: http.SameSiteNoneMode},
+	}
+	for key, options := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected Sessions to panic for cookie:", key)
+				}
+			}()
+			Sessions("my_secret", key, options)
+		}()
+	}
+
+	// Valid options don't panic
+	Sessions("my_secret", "__Secure-my_key", &CookieOptions{Secure: true})
+	Sessions("my_secret", "__Host-my_key", &CookieOptions{Secure: true, Path: "/"})
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"io"
 )
 
 func ShowErrors(templateString string) Middleware {
@@ -29,9 +30,28 @@ func ShowErrors(templateString string) Middleware {
 				status = 500
 				headers = Headers{}
 				body = Body(buffer.String())
+				// Don't send a half-built stream instead of the error page
+				env.DiscardStream()
 			}
 		}()
 
-		return app(env)
+		status, headers, body = app(env)
+		if stream := env.Stream(); stream != nil {
+			env.SetStream(recoverStream(stream))
+		}
+		return
+	}
+}
+
+// The response has already started by the time a stream panics, so we
+// can't show the error page. Turn the panic into an error to be logged.
+func recoverStream(stream Stream) Stream {
+	return func(w io.Writer) (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = fmt.Errorf("%s", e)
+			}
+		}()
+		return stream(w)
 	}
 }
@@ -1,7 +1,10 @@
 package mango
 
 import (
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -49,3 +52,64 @@ func BenchmarkShowErrors(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func TestShowErrorsDropsStream(t *testing.T) {
+	reader := &closeRecorder{Reader: strings.NewReader("partial")}
+	streamThenPanic := func(env Env) (Status, Headers, Body) {
+		env.SetStream(ReaderStream(reader))
+		panic("foo!")
+	}
+
+	// Compile the stack
+	showErrorsStack := new(Stack)
+	showErrorsStack.Middleware(ShowErrors("<html><body>{{.Error|html}}</body></html>"))
+	showErrorsApp := showErrorsStack.Compile(streamThenPanic)
+
+	// Request against it
+	request, err := http.NewRequest("GET", "http://localhost:3000/", nil)
+	env := Env{"mango.request": &Request{request}}
+	status, _, body := showErrorsApp(env)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if status != 500 {
+		t.Error("Expected status to equal 500, got:", status)
+	}
+
+	if env.Stream() != nil {
+		t.Error("Expected the stream to be removed")
+	}
+
+	if !reader.closed {
+		t.Error("Expected the stream's reader to be closed")
+	}
+
+	expected := "<html><body>foo!</body></html>"
+	if string(body) != expected {
+		t.Error("Expected response body to equal: \"", expected, "\" got: \"", string(body), "\"")
+	}
+}
+
+func TestShowErrorsRecoversStream(t *testing.T) {
+	panickyStream := func(env Env) (Status, Headers, Body) {
+		return Streaming(env, 200, Headers{}, func(w io.Writer) error {
+			panic("foo!")
+		})
+	}
+
+	// Compile the stack
+	showErrorsStack := new(Stack)
+	showErrorsStack.Middleware(ShowErrors(""))
+	showErrorsApp := showErrorsStack.Compile(panickyStream)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	env := Env{"mango.request": &Request{request}}
+	showErrorsApp(env)
+
+	err := env.Stream()(ioutil.Discard)
+	if err == nil || err.Error() != "foo!" {
+		t.Error("Expected stream error to equal \"foo!\", got:", err)
+	}
+}
@@ -0,0 +1,56 @@
+package mango
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+var errStreamDiscarded = errors.New("mango: stream discarded")
+
+// Refuses every write, so a stream being discarded stops at its first
+type discardWriter struct{}
+
+func (this discardWriter) Write(p []byte) (int, error) {
+	return 0, errStreamDiscarded
+}
+
+// Build a Stream which copies the response body from reader. If the
+// reader is also an io.Closer it is closed once the copy is done.
+func ReaderStream(reader io.Reader) Stream {
+	return func(w io.Writer) error {
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+		_, err := io.Copy(w, reader)
+		return err
+	}
+}
+
+// Respond with a streamed body, e.g.:
+//
+//	return mango.Streaming(env, 200, headers, mango.ReaderStream(file))
+func Streaming(env Env, status Status, headers Headers, stream Stream) (Status, Headers, Body) {
+	env.SetStream(stream)
+	return status, headers, Body("")
+}
+
+// flushWriter flushes the underlying ResponseWriter after every write, so
+// streamed bodies reach the client as they are produced.
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushWriter{writer: w, flusher: flusher}
+}
+
+func (this *flushWriter) Write(p []byte) (n int, err error) {
+	n, err = this.writer.Write(p)
+	if this.flusher != nil {
+		this.flusher.Flush()
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package mango
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func streamingTestServer(env Env) (Status, Headers, Body) {
+	return Streaming(env, 200, Headers{"Content-Type": []string{"text/plain"}}, func(w io.Writer) error {
+		for i := 0; i < 3; i++ {
+			if _, err := io.WriteString(w, "chunk "); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestStreaming(t *testing.T) {
+	stack := new(Stack)
+	testServer := httptest.NewServer(stack.HandlerFunc(streamingTestServer))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		t.Error("Expected status to equal 200, got:", response.StatusCode)
+	}
+
+	// Flushed as it went, so there's no Content-Length
+	if response.ContentLength != -1 {
+		t.Error("Expected a chunked response, got Content-Length:", response.ContentLength)
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	expected := "chunk chunk chunk "
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestReaderStream(t *testing.T) {
+	readerTestServer := func(env Env) (Status, Headers, Body) {
+		return Streaming(env, 200, Headers{}, ReaderStream(strings.NewReader("Hello World!")))
+	}
+
+	stack := new(Stack)
+	testServer := httptest.NewServer(stack.HandlerFunc(readerTestServer))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	expected := "Hello World!"
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+func TestStreamingWithSessions(t *testing.T) {
+	sessionsStreamingTestServer := func(env Env) (Status, Headers, Body) {
+		env.Session()["streamed"] = true
+		return streamingTestServer(env)
+	}
+
+	stack := new(Stack)
+	stack.Middleware(Sessions("my_secret", "my_key", &CookieOptions{}))
+	testServer := httptest.NewServer(stack.HandlerFunc(sessionsStreamingTestServer))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.Header.Get("Set-Cookie") == "" {
+		t.Error("Expected the Set-Cookie header to be set")
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	expected := "chunk chunk chunk "
+	if string(body) != expected {
+		t.Error("Expected body:", string(body), "to equal:", expected)
+	}
+}
+
+// A reader which records whether it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (this *closeRecorder) Close() error {
+	this.closed = true
+	return nil
+}
+
+func TestDiscardStream(t *testing.T) {
+	reader := &closeRecorder{Reader: strings.NewReader("Hello World!")}
+	env := Env{}
+	env.SetStream(ReaderStream(reader))
+	env.DiscardStream()
+
+	if env.Stream() != nil {
+		t.Error("Expected the stream to be removed")
+	}
+	if !reader.closed {
+		t.Error("Expected the stream's reader to be closed")
+	}
+
+	// Panicking streams are ignored
+	env.SetStream(func(w io.Writer) error {
+		panic("foo!")
+	})
+	env.DiscardStream()
+}
@@ -0,0 +1,151 @@
+package mango
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Build a Router which matches paths with a prefix tree of path segments,
+// rather than trying the routes' regexes one by one. Lookups take the same
+// time however many routes there are, so it suits large route tables.
+//
+// Patterns are made up of segments which are either literal text, a
+// ":name" param matching one segment, or a final "*name" wildcard matching
+// the rest of the path. Regexes aren't supported. Literal segments are
+// preferred over params, and params over wildcards, whatever order routes
+// are added in or their Priority. If the preferred route doesn't handle the
+// request's method, the less specific ones are tried, as with Router:
+//
+//	router := mango.NewTreeRouter()
+//	router.Get("/users/:id", showUser)
+//	router.Get("/users/new", newUser)
+//	router.Get("/files/*path", serveFile)
+func NewTreeRouter() *Router {
+	return &Router{tree: true}
+}
+
+// Build the regex for a tree pattern, for building URLs and checking
+// matches. Literal segments are quoted, so "." only matches ".".
+func expandTreePattern(pattern string) string {
+	segments := splitPath(pattern)
+	expanded := make([]string, len(segments))
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			expanded[i] = fmt.Sprintf("(?P<%s>[^/]+)", segment[1:])
+		case strings.HasPrefix(segment, "*"):
+			if i != len(segments)-1 {
+				panic(fmt.Sprintf("mango: wildcard %q must be at the end of route %q", segment, pattern))
+			}
+			expanded[i] = fmt.Sprintf("(?P<%s>.*)", segment[1:])
+		default:
+			expanded[i] = regexp.QuoteMeta(segment)
+		}
+	}
+	return "/" + strings.Join(expanded, "/")
+}
+
+// "/users/123" is split into ["users", "123"]
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+type node struct {
+	static   map[string]*node
+	param    *node
+	wildcard *node
+	// The routes ending at this node, by priority
+	routes []*Route
+}
+
+func buildTree(routes routeArray) *node {
+	root := new(node)
+	for _, route := range routes {
+		root.add(splitPath(route.Pattern), route)
+	}
+	return root
+}
+
+func (this *node) add(segments []string, route *Route) {
+	if len(segments) == 0 {
+		for _, existing := range this.routes {
+			if existing.Method == route.Method {
+				log.Printf("mango: route %s %q is ambiguous with %q, which takes precedence", route.Method, route.Pattern, existing.Pattern)
+			}
+		}
+		this.routes = append(this.routes, route)
+		return
+	}
+
+	segment := segments[0]
+	switch {
+	case strings.HasPrefix(segment, ":"):
+		if this.param == nil {
+			this.param = new(node)
+		}
+		this.param.add(segments[1:], route)
+	case strings.HasPrefix(segment, "*"):
+		if this.wildcard == nil {
+			this.wildcard = new(node)
+		}
+		this.wildcard.add(nil, route)
+	default:
+		if this.static == nil {
+			this.static = make(map[string]*node)
+		}
+		if this.static[segment] == nil {
+			this.static[segment] = new(node)
+		}
+		this.static[segment].add(segments[1:], route)
+	}
+}
+
+func (this *node) lookup(path, method string) (found, get *routeMatch, allowed []string) {
+	this.walk(splitPath(path), nil, func(leaf *node, values []string) bool {
+		// The values line up with the named groups in the routes' regexes
+		matches := append([]string{path}, values...)
+		for _, route := range leaf.routes {
+			if route.Method == method {
+				found = &routeMatch{route, matches}
+				return true
+			}
+			if route.Method == "GET" && get == nil {
+				get = &routeMatch{route, matches}
+			}
+			allowed = append(allowed, route.Method)
+		}
+		return false
+	})
+
+	if found != nil {
+		return found, nil, nil
+	}
+	return nil, get, allowed
+}
+
+// Visit each node with routes matching the path segments, most specific
+// first, collecting the values of params along the way. Less specific
+// nodes are only tried when visit returns false, e.g. because the more
+// specific node has no route for the method. Returns whether visit returned
+// true.
+func (this *node) walk(segments []string, values []string, visit func(*node, []string) bool) bool {
+	if len(segments) == 0 {
+		return len(this.routes) > 0 && visit(this, values)
+	}
+
+	if child := this.static[segments[0]]; child != nil && child.walk(segments[1:], values, visit) {
+		return true
+	}
+
+	if this.param != nil && segments[0] != "" && this.param.walk(segments[1:], append(values, segments[0]), visit) {
+		return true
+	}
+
+	if this.wildcard != nil {
+		return visit(this.wildcard, append(values, strings.Join(segments, "/")))
+	}
+
+	return false
+}
@@ -0,0 +1,40 @@
+package mango
+
+// Who made a request, as stored in the env by the auth middleware
+type User struct {
+	Name string
+	// Optional, for RequireRole
+	Roles []string
+	// Anything else known about the user
+	Claims map[string]interface{}
+}
+
+func (this *User) HasRole(role string) bool {
+	for _, r := range this.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// The user the auth middleware accepted, or nil
+func (this Env) User() *User {
+	user, _ := this["mango.user"].(*User)
+	return user
+}
+
+// Only let through requests from users with at least one of the roles. Must
+// come after an auth middleware.
+func RequireRole(roles ...string) Middleware {
+	return func(env Env, app App) (Status, Headers, Body) {
+		if user := env.User(); user != nil {
+			for _, role := range roles {
+				if user.HasRole(role) {
+					return app(env)
+				}
+			}
+		}
+		return forbidden()
+	}
+}
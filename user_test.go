@@ -0,0 +1,44 @@
+package mango
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequireRole(t *testing.T) {
+	userAuth := func(username string, password string, req Request, err error) *User {
+		if password != "foo" {
+			return nil
+		}
+		if username == "admin" {
+			return &User{Name: username, Roles: []string{"editor", "admin"}}
+		}
+		return &User{Name: username}
+	}
+
+	roleStack := new(Stack)
+	roleStack.Middleware(BasicAuthUser(userAuth, nil), RequireRole("admin", "owner"))
+	roleApp := roleStack.Compile(successPage)
+
+	statuses := map[string]Status{"admin": 200, "foo": 403}
+	for username, expected := range statuses {
+		request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		request.SetBasicAuth(username, "foo")
+		status, _, _ := roleApp(Env{"mango.request": &Request{request}})
+
+		if status != expected {
+			t.Error("Expected", username, "to get status:", expected, "got:", status)
+		}
+	}
+
+	// Without an auth middleware, there's no user
+	noAuthStack := new(Stack)
+	noAuthStack.Middleware(RequireRole("admin"))
+	noAuthApp := noAuthStack.Compile(successPage)
+
+	request, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	status, _, _ := noAuthApp(Env{"mango.request": &Request{request}})
+	if status != 403 {
+		t.Error("Expected status: 403, got:", status)
+	}
+}
@@ -0,0 +1,144 @@
+package mango
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+type virtualHost struct {
+	pattern  string
+	scheme   string
+	labels   []string
+	wildcard bool
+	app      App
+}
+
+// How specific a host pattern is, for sorting. Patterns for a scheme come
+// first, then those without a wildcard, then those with the most literal
+// labels.
+func (this *virtualHost) specificity() (score int) {
+	if this.scheme != "" {
+		score += 1000
+	}
+	if !this.wildcard {
+		score += 100
+	}
+	for _, label := range this.labels {
+		if !strings.HasPrefix(label, ":") {
+			score++
+		}
+	}
+	return
+}
+
+// Methods required by sort.Interface.
+type virtualHostArray []*virtualHost
+
+func (this virtualHostArray) Len() int {
+	return len(this)
+}
+func (this virtualHostArray) Less(i, j int) bool {
+	if this[i].specificity() == this[j].specificity() {
+		return this[i].pattern < this[j].pattern
+	}
+	return this[i].specificity() > this[j].specificity()
+}
+func (this virtualHostArray) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+func parseVirtualHost(pattern string, app App) *virtualHost {
+	host := &virtualHost{pattern: pattern, app: app}
+
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		host.scheme = strings.ToLower(pattern[:i])
+		pattern = pattern[i+3:]
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		host.wildcard = true
+		pattern = pattern[2:]
+	}
+
+	host.labels = strings.Split(strings.ToLower(pattern), ".")
+	return host
+}
+
+// Match the host's labels, returning any params captured
+func (this *virtualHost) match(scheme string, labels []string) (map[string]string, bool) {
+	if this.scheme != "" && this.scheme != scheme {
+		return nil, false
+	}
+
+	if this.wildcard {
+		// The wildcard matches one or more labels
+		if len(labels) <= len(this.labels) {
+			return nil, false
+		}
+		labels = labels[len(labels)-len(this.labels):]
+	} else if len(labels) != len(this.labels) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, label := range this.labels {
+		if strings.HasPrefix(label, ":") {
+			params[label[1:]] = labels[i]
+		} else if label != labels[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func requestScheme(request *Request) string {
+	if request.URL.Scheme != "" {
+		return strings.ToLower(request.URL.Scheme)
+	}
+	if request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Dispatch requests to apps by their Host, and optionally their scheme.
+// "hosts" is of the form:
+//
+//	{
+//	  "example.com":                mainApp,   // exactly example.com
+//	  "*.example.com":              otherApp,  // any subdomain of example.com
+//	  ":tenant.example.com":        tenantApp, // sets env.Param("tenant")
+//	  "https://secure.example.com": secureApp, // only over https
+//	}
+//
+// The most specific match wins: patterns with a scheme first, then exact
+// hosts, then those with the most literal labels. Requests for any other
+// host are passed upstream.
+func VirtualHosts(hosts map[string]App) Middleware {
+	matchers := virtualHostArray{}
+	for pattern, app := range hosts {
+		matchers = append(matchers, parseVirtualHost(pattern, app))
+	}
+	sort.Sort(matchers)
+
+	return func(env Env, app App) (Status, Headers, Body) {
+		request := env.Request()
+		host := request.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+		scheme := requestScheme(request)
+
+		for _, matcher := range matchers {
+			if params, ok := matcher.match(scheme, labels); ok {
+				addParams(env, params)
+				return matcher.app(env)
+			}
+		}
+
+		// didn't match any of the hosts. pass upstream.
+		return app(env)
+	}
+}
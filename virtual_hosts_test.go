@@ -0,0 +1,53 @@
+package mango
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	hostTestServer := func(name string) App {
+		return func(env Env) (Status, Headers, Body) {
+			return 200, Headers{}, Body(name + " " + env.Param("tenant"))
+		}
+	}
+
+	// Compile the stack
+	hostsStack := new(Stack)
+	hostsStack.Middleware(VirtualHosts(map[string]App{
+		"example.com":                hostTestServer("main"),
+		"www.example.com":            hostTestServer("www"),
+		"*.example.com":              hostTestServer("wildcard"),
+		":tenant.example.com":        hostTestServer("tenant"),
+		":tenant.eu.example.com":     hostTestServer("eu tenant"),
+		"https://secure.example.com": hostTestServer("secure"),
+	}))
+	hostsApp := hostsStack.Compile(routingTestServer)
+
+	for _, test := range []struct {
+		url, expected string
+		tls           bool
+	}{
+		{"http://example.com/", "main ", false},
+		{"http://EXAMPLE.com:3000/", "main ", false},
+		{"http://www.example.com/", "www ", false},
+		{"http://acme.example.com/", "tenant acme", false},
+		{"http://acme.eu.example.com/", "eu tenant acme", false},
+		{"http://a.b.c.example.com/", "wildcard ", false},
+		{"https://secure.example.com/", "secure ", true},
+		{"http://secure.example.com/", "tenant secure", false},
+		{"http://other.com/", "Hello World!", false},
+	} {
+		request, _ := http.NewRequest("GET", test.url, nil)
+		request.URL.Scheme = ""
+		if test.tls {
+			request.TLS = &tls.ConnectionState{}
+		}
+		_, _, body := hostsApp(Env{"mango.request": &Request{request}})
+
+		if string(body) != test.expected {
+			t.Error("Expected body of", test.url, "to equal:", test.expected, "got:", string(body))
+		}
+	}
+}